@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Monekx/hyprlink/internal/config"
+)
+
+// buildOutput — то, что печатает -mode build: собранный UI, таблица действий и хэш.
+type buildOutput struct {
+	Hash    string                   `json:"hash"`
+	UI      *config.UIConfig         `json:"ui"`
+	Actions map[string]config.Action `json:"actions"`
+}
+
+func runBuild(configDir, outPath string) error {
+	if info, err := os.Stat(configDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("config directory %s not found", configDir)
+	}
+
+	bundle, err := config.BuildFullConfig(configDir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", configDir, err)
+	}
+
+	data, err := json.MarshalIndent(buildOutput{
+		Hash:    bundle.UI.Hash,
+		UI:      &bundle.UI,
+		Actions: bundle.Actions,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if outPath == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Config built: %s (hash %s)\n", outPath, bundle.UI.Hash)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain запускает main вместо тестов, когда бинарник теста вызван как hyprlink.
+func TestMain(m *testing.M) {
+	if os.Getenv("HYPRLINK_RUN_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func writeMain(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestBuildWritesBundle(t *testing.T) {
+	dir := writeMain(t, "profiles:\n  - name: P\n    modules:\n      - {id: lock, type: button, action: loginctl lock-session}\n")
+	out := filepath.Join(t.TempDir(), "bundle.json")
+	if err := runBuild(dir, out); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got buildOutput
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, data)
+	}
+	if got.Hash == "" || got.Hash != got.UI.Hash {
+		t.Errorf("hash %q, ui hash %q, want the same non-empty hash", got.Hash, got.UI.Hash)
+	}
+	if len(got.UI.Profiles) != 1 || got.UI.Profiles[0].Name != "P" || got.UI.Profiles[0].Modules[0].ID != "lock" {
+		t.Errorf("ui %+v, want profile P with lock", got.UI.Profiles)
+	}
+	if got.Actions["lock"].Command != "loginctl lock-session" {
+		t.Errorf("actions %v, want lock", got.Actions)
+	}
+}
+
+func TestBuildFailsOnDiagnostics(t *testing.T) {
+	dir := writeMain(t, "profiles:\n  - name: P\n    modules:\n      - {type: knob}\n")
+	out := filepath.Join(t.TempDir(), "bundle.json")
+
+	cmd := exec.Command(os.Args[0], "-mode", "build", "-config", dir, "-out", out)
+	cmd.Env = append(os.Environ(), "HYPRLINK_RUN_MAIN=1")
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("build exited with %v, want status 1\n%s", err, output)
+	}
+	if !strings.Contains(string(output), `main.yaml:4:16: unknown module type "knob"`) {
+		t.Errorf("output does not report the diagnostic:\n%s", output)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("output file written despite diagnostics: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+)
+
+const devicesUsage = `usage: hyprlink -mode devices [list]
+       hyprlink -mode devices rename <device-id> <name>
+       hyprlink -mode devices revoke <device-id>`
+
+// runDevices управляет trusted_devices.json. Запущенный сервер замечает изменения файла
+// и сразу отключает отозванные устройства.
+func runDevices(configDir string, args []string) error {
+	store := config.NewTrustedStore(filepath.Join(configDir, config.TrustedDevicesFile))
+
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch {
+	case cmd == "list" && len(args) <= 1:
+		return listDevices(store)
+	case cmd == "rename" && len(args) >= 3:
+		if err := store.Rename(args[1], strings.Join(args[2:], " ")); err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+		fmt.Printf("Device %s renamed\n", args[1])
+	case cmd == "revoke" && len(args) == 2:
+		if err := store.Revoke(args[1]); err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+		fmt.Printf("Device %s revoked\n", args[1])
+	default:
+		return fmt.Errorf("%s", devicesUsage)
+	}
+	return nil
+}
+
+func listDevices(store *config.TrustedStore) error {
+	devices, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		fmt.Println("No trusted devices")
+		return nil
+	}
+
+	ids := make([]string, 0, len(devices))
+	for id := range devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tCREATED\tLAST SEEN\tLAST IP")
+	for _, id := range ids {
+		dev := devices[id]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", id, dev.Name, formatTime(dev.CreatedAt), formatTime(dev.LastSeen), dev.LastIP)
+	}
+	return w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
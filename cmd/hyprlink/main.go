@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -9,10 +11,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sync"
 
 	"github.com/Monekx/hyprlink/internal/config"
 	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/Monekx/hyprlink/protocol"
 )
 
 func setupDefaultConfig(configDir string) {
@@ -60,67 +62,79 @@ func setupDefaultConfig(configDir string) {
 	}
 }
 
+// reportConfigError показывает проблемы конфига уведомлением, чтобы их не приходилось искать в логах.
+func reportConfigError(err error) {
+	msg := err.Error()
+	var diags config.Diagnostics
+	if errors.As(err, &diags) && len(diags) > 0 {
+		msg = diags[0].String()
+		if len(diags) > 1 {
+			msg += fmt.Sprintf("\n(и ещё %d, см. hyprlink -mode check)", len(diags)-1)
+		}
+	}
+	exec.Command("notify-send", "-a", "HyprLink", "Ошибка конфига", msg).Run()
+}
+
+func writeActionsDump(actions map[string]config.Action) {
+	path, err := config.DumpActions(actions)
+	if err != nil {
+		log.Printf("Error writing actions dump: %v\n", err)
+		return
+	}
+	fmt.Printf("Actions written to %s\n", path)
+}
+
+// dialLocal подключается к локальному серверу, по TLS, если сертификат сервера уже создан.
+func dialLocal(configDir string, port int) (net.Conn, error) {
+	addr := fmt.Sprintf("localhost:%d", port)
+	tlsConf, err := server.LocalClientTLS(configDir)
+	if err != nil {
+		return net.Dial("tcp", addr)
+	}
+	return tls.Dial("tcp", addr, tlsConf)
+}
+
+func defaultConfigDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return filepath.Join(home, ".config", "hyprlink")
+}
+
 func main() {
-	mode := flag.String("mode", "serve", "serve | build | get")
+	mode := flag.String("mode", "serve", "serve | build | check | get | devices")
 	port := flag.Int("port", 8080, "TCP Port")
 	target := flag.String("target", "all", "Target for get mode")
+	device := flag.String("device", "", "Device ID to query in get mode (required when several are connected)")
+	dir := flag.String("config", "", "Config directory (default ~/.config/hyprlink)")
+	out := flag.String("out", "", "Output file for build mode (default stdout)")
+	tlsOnly := flag.Bool("tls-only", false, "Refuse clients that do not use TLS")
+	dumpActions := flag.Bool("dump-actions", false, "Write generated actions to $XDG_STATE_HOME/hyprlink/actions.yaml (debug)")
 	flag.Parse()
 
+	configDir := *dir
+	if configDir == "" {
+		configDir = defaultConfigDir()
+	}
+
 	switch *mode {
 	case "serve":
-		var mu sync.RWMutex
-		home, err := os.UserHomeDir()
-		if err != nil {
+		if err := runServe(configDir, *port, *tlsOnly, *dumpActions); err != nil {
 			log.Fatal(err)
 		}
-		configDir := filepath.Join(home, ".config", "hyprlink")
-		os.MkdirAll(configDir, 0755)
-
-		setupDefaultConfig(configDir)
-
-		fullCfg, err := config.BuildFullConfig(configDir)
-		if err != nil {
-			// Если конфиг битый или его нет, не падаем сразу, а пробуем подождать
-			log.Printf("Error loading config: %v\n", err)
-		}
-
-		// Запускаем вотчер
-		config.WatchConfig(configDir, func() {
-			newCfg, err := config.BuildFullConfig(configDir)
-			if err == nil {
-				mu.Lock()
-				fullCfg = newCfg
-				mu.Unlock()
-				fmt.Printf("Config reloaded, new hash: %s\n", fullCfg.UI.Hash)
-				server.UpdateConfig(&fullCfg.UI, fullCfg.Actions)
-				server.BroadcastUpdate(&fullCfg.UI)
-			} else {
-				fmt.Printf("Error reloading config: %v\n", err)
-			}
-		})
-
-		if fullCfg != nil {
-			fmt.Printf("HyprLink: %s (Hash: %s)\n", fullCfg.UI.Hostname, fullCfg.UI.Hash)
-			server.UpdateConfig(&fullCfg.UI, fullCfg.Actions)
-		} else {
-			fmt.Println("HyprLink started without valid config. Waiting for changes...")
-			// Инициализируем пустыми значениями, чтобы сервер не упал
-			server.UpdateConfig(&config.UIConfig{}, make(map[string]string))
-		}
-
-		go server.ListenForDevices(*port)
-		server.StartTCPServer(*port, &fullCfg.UI, fullCfg.Actions)
 
 	case "get":
-		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", *port))
+		conn, err := dialLocal(configDir, *port)
 		if err != nil {
 			log.Fatal("Is hyprlink serve running?")
 		}
 		defer conn.Close()
 
 		req := map[string]string{
-			"type": "get_request",
-			"id":   *target,
+			"type":      protocol.TypeGetRequest,
+			"id":        *target,
+			"device_id": *device,
 		}
 		json.NewEncoder(conn).Encode(req)
 
@@ -131,5 +145,31 @@ func main() {
 
 		output, _ := json.MarshalIndent(response, "", "  ")
 		fmt.Println(string(output))
+		if _, failed := response["error"]; failed {
+			os.Exit(1)
+		}
+
+	case "check":
+		if _, err := config.BuildFullConfig(configDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Config OK")
+
+	case "devices":
+		if err := runDevices(configDir, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "build":
+		if err := runBuild(configDir, *out); err != nil {
+			fmt.Fprintf(os.Stderr, "Build failed: %v\n", err)
+			os.Exit(1)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown mode: %s\n", *mode)
+		os.Exit(2)
 	}
 }
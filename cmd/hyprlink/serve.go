@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/server"
+)
+
+func runServe(configDir string, port int, tlsOnly, dumpActions bool) error {
+	var mu sync.Mutex
+	os.MkdirAll(configDir, 0755)
+
+	setupDefaultConfig(configDir)
+
+	// Конфиг применяется целиком или никак, и при запуске, и при перезагрузке:
+	// частично собранная раскладка выглядела бы рабочей, скрывая ошибки
+	fullCfg, err := config.BuildFullConfig(configDir)
+	if err != nil {
+		// Если конфиг битый или его нет, не падаем сразу, а ждём исправления
+		log.Printf("Error loading config: %v\n", err)
+		reportConfigError(err)
+		fullCfg = nil
+	}
+
+	opts := server.Options{Port: port, ConfigDir: configDir, RequireTLS: tlsOnly}
+	cert, _, err := server.LoadOrCreateCertificate(configDir)
+	if err != nil {
+		if tlsOnly {
+			return fmt.Errorf("TLS certificate: %w", err)
+		}
+		log.Printf("TLS disabled, certificate error: %v\n", err)
+	} else {
+		opts.Certificate = &cert
+	}
+	srv := server.New(opts)
+
+	if fullCfg != nil {
+		fmt.Printf("HyprLink: %s (Hash: %s)\n", fullCfg.UI.Hostname, fullCfg.UI.Hash)
+		srv.UpdateConfig(fullCfg)
+		if dumpActions {
+			writeActionsDump(fullCfg.Actions)
+		}
+	} else {
+		fmt.Println("HyprLink started without a config: nothing is served until every problem is fixed (see hyprlink -mode check). Waiting for changes...")
+	}
+	if fp := srv.Fingerprint(); fp != "" {
+		fmt.Printf("TLS fingerprint: %s\n", fp)
+	}
+
+	// Запускаем вотчер
+	config.WatchConfig(configDir, func() {
+		newCfg, err := config.BuildFullConfig(configDir)
+		if err != nil {
+			// Битый конфиг не применяем: телефон остаётся на последней рабочей версии
+			fmt.Printf("Error reloading config, keeping the previous one: %v\n", err)
+			reportConfigError(err)
+			return
+		}
+		mu.Lock()
+		changed := fullCfg == nil || fullCfg.UI.Hash != newCfg.UI.Hash
+		fullCfg = newCfg
+		mu.Unlock()
+		srv.UpdateConfig(newCfg)
+		if dumpActions {
+			writeActionsDump(newCfg.Actions)
+		}
+		// Команды (source/action) в хэш не входят, их достаточно обновить на сервере
+		if changed {
+			fmt.Printf("Config reloaded, new hash: %s\n", newCfg.UI.Hash)
+			srv.BroadcastUpdate(&newCfg.UI)
+		}
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := srv.Start(ctx); err != nil {
+		return err
+	}
+	go server.ListenForDevices(srv.Addr().(*net.TCPAddr).Port)
+
+	<-ctx.Done()
+	fmt.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
@@ -5,51 +5,156 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Monekx/hyprlink/protocol"
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	// DefaultMaxImportDepth — глубина цепочки import, если в main.yaml не задан max_import_depth.
+	DefaultMaxImportDepth = 16
+	// DefaultInterval — период опроса source, если у модуля не задан interval.
+	DefaultInterval = time.Second
+	// MinInterval не даёт случайно запускать source чаще десяти раз в секунду.
+	MinInterval = 100 * time.Millisecond
+	// DefaultSourceTimeout и DefaultActionTimeout действуют, если у модуля не задан timeout.
+	DefaultSourceTimeout = 5 * time.Second
+	DefaultActionTimeout = 30 * time.Second
+	// DefaultClipboardMaxSize и DefaultClipboardHistory действуют, если они не заданы в секции clipboard.
+	DefaultClipboardMaxSize = 4 << 20
+	DefaultClipboardHistory = 20
+)
+
 type ConfigBundle struct {
 	UI      UIConfig
-	Actions map[string]string
+	Actions map[string]Action
+	// Settings — настройки самого сервера из main.yaml, телефону они не отправляются.
+	Settings Settings
+}
+
+// Settings — серверные секции main.yaml с уже подставленными значениями по умолчанию.
+type Settings struct {
+	Clipboard     ClipboardConfig
+	Notifications NotificationsConfig
+	Connection    ConnectionConfig
+}
+
+// DefaultSettings — настройки для пустого main.yaml.
+func DefaultSettings() Settings {
+	return Settings{
+		Clipboard: ClipboardConfig{Policy: ClipboardBoth, MaxSize: DefaultClipboardMaxSize, History: DefaultClipboardHistory},
+		Connection: ConnectionConfig{
+			Heartbeat: Duration(DefaultHeartbeat),
+			Timeout:   Duration(DefaultClientTimeout),
+			Resume:    Duration(DefaultResumeWindow),
+		},
+	}
+}
+
+// builder собирает конфиг и копит диагностики вместо того, чтобы молча пропускать битые модули.
+type builder struct {
+	baseDir  string
+	maxDepth int
+	actions  map[string]Action
+	// actionAt — где объявлено каждое действие; действия общие для всех профилей.
+	actionAt map[string]string
+	diags    Diagnostics
+	// tabIDs — явные id модулей текущего профиля и место, где они объявлены.
+	tabIDs map[string]string
+	// sources — source модулей с явным id во всех профилях, sourceAt — где они объявлены.
+	sources  map[string]sourceDef
+	sourceAt map[string]string
 }
 
+// sourceDef — всё, что определяет значение модуля.
+type sourceDef struct {
+	source   string
+	mode     string
+	interval Duration
+	timeout  Duration
+}
+
+// rawModule — модуль после разрешения import, но до генерации id и действий.
+type rawModule struct {
+	Module
+	file       string
+	node       *yaml.Node
+	children   []*yaml.Node
+	childFile  string
+	childChain []string
+}
+
+// moduleFields декодирует поля модуля без UnmarshalYAML (детей разбираем сами, с позициями).
+type moduleFields Module
+
+// BuildFullConfig собирает UIConfig и таблицу действий из configDir.
+// Если часть конфига не удалось разобрать, возвращает собранное вместе с ошибкой типа Diagnostics.
 func BuildFullConfig(configDir string) (*ConfigBundle, error) {
-	mainData, err := os.ReadFile(filepath.Join(configDir, "main.yaml"))
+	b := &builder{
+		baseDir:  configDir,
+		maxDepth: DefaultMaxImportDepth,
+		actions:  make(map[string]Action),
+		actionAt: make(map[string]string),
+		sources:  make(map[string]sourceDef),
+		sourceAt: make(map[string]string),
+	}
+
+	mainFile := "main.yaml"
+	mainData, err := os.ReadFile(filepath.Join(configDir, mainFile))
 	if err != nil {
-		mainData, err = os.ReadFile(filepath.Join(configDir, "main.json"))
+		mainFile = "main.json"
+		mainData, err = os.ReadFile(filepath.Join(configDir, mainFile))
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	var main MainConfig
-	if err := yaml.Unmarshal(mainData, &main); err != nil {
-		return nil, err
+	mainNode, err := parseDocument(mainData)
+	if err != nil {
+		return nil, yamlErrorDiags(mainFile, err)
+	}
+	if mainNode.Kind != yaml.MappingNode {
+		return nil, Diagnostics{b.diag(mainFile, mainNode, "main config must be a mapping")}
+	}
+	b.checkKeys(mainFile, mainNode, mainKeys, "main config")
+	if n := mappingValue(mainNode, "max_import_depth"); n != nil {
+		var depth int
+		if err := n.Decode(&depth); err != nil || depth < 1 {
+			b.errorf(mainFile, n, "max_import_depth must be a positive integer")
+		} else {
+			b.maxDepth = depth
+		}
 	}
 
-	actions := make(map[string]string)
+	settings := Settings{
+		Clipboard:     b.clipboardSettings(mainFile, mappingValue(mainNode, "clipboard")),
+		Notifications: b.notificationSettings(mainFile, mappingValue(mainNode, "notifications")),
+		Connection:    b.connectionSettings(mainFile, mappingValue(mainNode, "connection")),
+	}
 
 	var ui UIConfig
-	ui.Hostname = main.Hostname
 	ui.Profiles = []Tab{}
-
-	for _, prof := range main.Profiles {
-		loadedProf, err := resolveProfile(configDir, prof)
-		if err != nil {
-			continue
+	if n := mappingValue(mainNode, "hostname"); n != nil {
+		if err := n.Decode(&ui.Hostname); err != nil {
+			b.diags = append(b.diags, yamlErrorDiags(mainFile, err)...)
 		}
+	}
 
-		tab := Tab{Name: loadedProf.Name, Modules: []Module{}}
-		for _, mod := range loadedProf.Modules {
-			resolvedMod, err := resolveModule(configDir, mod, actions)
-			if err != nil {
-				continue
+	if profiles := mappingValue(mainNode, "profiles"); profiles != nil {
+		if profiles.Kind != yaml.SequenceNode {
+			b.errorf(mainFile, profiles, "profiles must be a list")
+		} else {
+			for i, node := range profiles.Content {
+				if tab, ok := b.buildTab(mainFile, node, []string{mainFile}, strconv.Itoa(i)); ok {
+					ui.Profiles = append(ui.Profiles, tab)
+				}
 			}
-			tab.Modules = append(tab.Modules, resolvedMod)
 		}
-		ui.Profiles = append(ui.Profiles, tab)
 	}
 
 	cssData, _ := os.ReadFile(filepath.Join(configDir, "style.css"))
@@ -58,101 +163,540 @@ func BuildFullConfig(configDir string) (*ConfigBundle, error) {
 	hashData, _ := json.Marshal(ui)
 	ui.Hash = fmt.Sprintf("%x", md5.Sum(hashData))
 
-	actions["_note"] = "AUTOGENERATED FROM WIDGET CONFIGS. DO NOT EDIT."
-	actionsPath := filepath.Join(configDir, "actions.yaml")
-	actionsData, _ := yaml.Marshal(actions)
-	os.WriteFile(actionsPath, actionsData, 0644)
-
-	return &ConfigBundle{UI: ui, Actions: actions}, nil
+	bundle := &ConfigBundle{UI: ui, Actions: b.actions, Settings: settings}
+	if len(b.diags) > 0 {
+		return bundle, b.diags
+	}
+	return bundle, nil
 }
 
-func resolveProfile(baseDir string, p Profile) (Profile, error) {
-	if p.Import != "" {
-		path := filepath.Join(baseDir, p.Import)
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return Profile{}, err
+// clipboardSettings разбирает секцию clipboard; неверные политики отмечаются и заменяются на both.
+func (b *builder) clipboardSettings(file string, node *yaml.Node) ClipboardConfig {
+	cfg := DefaultSettings().Clipboard
+	if node == nil {
+		return cfg
+	}
+	if node.Kind != yaml.MappingNode {
+		b.errorf(file, node, "clipboard must be a mapping")
+		return cfg
+	}
+	b.checkKeys(file, node, clipboardKeys, "clipboard")
+	var raw ClipboardConfig
+	if err := node.Decode(&raw); err != nil {
+		b.diags = append(b.diags, yamlErrorDiags(file, err)...)
+		return cfg
+	}
+
+	validPolicy := func(p string) bool {
+		return p == ClipboardBoth || p == ClipboardPush || p == ClipboardPull || p == ClipboardOff
+	}
+	if raw.Policy != "" {
+		if validPolicy(raw.Policy) {
+			cfg.Policy = raw.Policy
+		} else {
+			b.errorf(file, valueOr(node, "policy"), "unknown clipboard policy %q (expected both, push, pull or off)", raw.Policy)
+		}
+	}
+	if devices := mappingValue(node, "devices"); devices != nil {
+		cfg.Devices = make(map[string]string)
+		for id, p := range raw.Devices {
+			if validPolicy(p) {
+				cfg.Devices[id] = p
+			} else {
+				b.errorf(file, valueOr(devices, id), "unknown clipboard policy %q for device %s", p, id)
+			}
+		}
+	}
+	if n := mappingValue(node, "max_size"); n != nil {
+		if raw.MaxSize < 1 {
+			b.errorf(file, n, "max_size must be a positive number of bytes")
+		} else {
+			cfg.MaxSize = raw.MaxSize
+		}
+	}
+	if n := mappingValue(node, "history"); n != nil {
+		if raw.History < 1 {
+			b.errorf(file, n, "history must be a positive integer")
+		} else {
+			cfg.History = raw.History
 		}
-		var loaded Profile
-		if err := yaml.Unmarshal(data, &loaded); err != nil {
-			return Profile{}, err
+	}
+	return cfg
+}
+
+// connectionSettings разбирает секцию connection. Timeout не меньше двух heartbeat,
+// иначе один потерянный пинг рвал бы соединение.
+func (b *builder) connectionSettings(file string, node *yaml.Node) ConnectionConfig {
+	cfg := DefaultSettings().Connection
+	if node == nil {
+		return cfg
+	}
+	if node.Kind != yaml.MappingNode {
+		b.errorf(file, node, "connection must be a mapping")
+		return cfg
+	}
+	b.checkKeys(file, node, connectionKeys, "connection")
+	var raw ConnectionConfig
+	if err := node.Decode(&raw); err != nil {
+		b.diags = append(b.diags, yamlErrorDiags(file, err)...)
+		return cfg
+	}
+	cfg.Heartbeat = Duration(raw.Heartbeat.Or(time.Duration(cfg.Heartbeat)))
+	cfg.Resume = Duration(raw.Resume.Or(time.Duration(cfg.Resume)))
+	if raw.Timeout > 0 {
+		cfg.Timeout = raw.Timeout
+	} else if cfg.Timeout < 2*cfg.Heartbeat {
+		cfg.Timeout = 3 * cfg.Heartbeat
+	}
+	if cfg.Timeout < 2*cfg.Heartbeat {
+		b.errorf(file, valueOr(node, "timeout"), "timeout %s must be at least twice the heartbeat %s",
+			time.Duration(cfg.Timeout), time.Duration(cfg.Heartbeat))
+		cfg.Timeout = 2 * cfg.Heartbeat
+	}
+	return cfg
+}
+
+// notificationSettings разбирает секцию notifications; неверные правила пропускаются.
+func (b *builder) notificationSettings(file string, node *yaml.Node) NotificationsConfig {
+	var cfg NotificationsConfig
+	if node == nil {
+		return cfg
+	}
+	if node.Kind != yaml.MappingNode {
+		b.errorf(file, node, "notifications must be a mapping")
+		return cfg
+	}
+	b.checkKeys(file, node, notificationKeys, "notifications")
+
+	cfg.Mute = b.notificationRules(file, mappingValue(node, "mute"), "mute")
+	cfg.Forward = b.notificationRules(file, mappingValue(node, "forward"), "forward")
+	if n := mappingValue(node, "hide_when_locked"); n != nil {
+		if err := n.Decode(&cfg.HideWhenLocked); err != nil {
+			b.errorf(file, n, "hide_when_locked must be true or false")
 		}
+	}
+	return cfg
+}
 
-		if p.Name != "" {
-			loaded.Name = p.Name
+func (b *builder) notificationRules(file string, list *yaml.Node, what string) []NotificationRule {
+	if list == nil {
+		return nil
+	}
+	if list.Kind != yaml.SequenceNode {
+		b.errorf(file, list, "%s must be a list", what)
+		return nil
+	}
+	var rules []NotificationRule
+	for _, ruleNode := range list.Content {
+		if ruleNode.Kind != yaml.MappingNode {
+			b.errorf(file, ruleNode, "%s rule must be a mapping", what)
+			continue
+		}
+		b.checkKeys(file, ruleNode, notificationRuleKeys, what+" rule")
+		var rule NotificationRule
+		if err := ruleNode.Decode(&rule); err != nil {
+			b.diags = append(b.diags, yamlErrorDiags(file, err)...)
+			continue
+		}
+		if rule.App == "" && rule.Title == "" {
+			b.errorf(file, ruleNode, "%s rule needs app or title", what)
+			continue
+		}
+		valid := true
+		for _, f := range []struct{ key, pattern string }{{"app", rule.App}, {"title", rule.Title}} {
+			if _, err := path.Match(f.pattern, ""); err != nil {
+				b.errorf(file, valueOr(ruleNode, f.key), "bad %s pattern %q: %v", f.key, f.pattern, err)
+				valid = false
+			}
+		}
+		if valid {
+			rules = append(rules, rule)
 		}
-		return loaded, nil
 	}
-	return p, nil
+	return rules
 }
 
-func resolveModule(baseDir string, m Module, actions map[string]string) (Module, error) {
-	if m.Import != "" {
-		path := filepath.Join(baseDir, m.Import)
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return Module{}, err
+func (b *builder) buildTab(file string, node *yaml.Node, chain []string, path string) (Tab, bool) {
+	name, modules, modFile, modChain, ok := b.resolveProfile(file, node, chain)
+	if !ok {
+		return Tab{}, false
+	}
+
+	b.tabIDs = make(map[string]string)
+	tab := Tab{Name: name, Modules: []Module{}}
+	for i, modNode := range modules {
+		raw, ok := b.resolveModule(modFile, modNode, modChain)
+		if !ok {
+			continue
 		}
-		var loaded Module
-		if err := yaml.Unmarshal(data, &loaded); err != nil {
-			return Module{}, err
+		if mod, ok := b.finalizeModule(raw, fmt.Sprintf("%s/%d", path, i)); ok {
+			tab.Modules = append(tab.Modules, mod)
 		}
+	}
+	return tab, true
+}
+
+// resolveProfile возвращает имя профиля и узлы его модулей вместе с файлом и цепочкой import, в которых они лежат.
+// chain — файлы, через которые мы пришли к file, включая его самого.
+func (b *builder) resolveProfile(file string, node *yaml.Node, chain []string) (string, []*yaml.Node, string, []string, bool) {
+	var p Profile
+	var modules *yaml.Node
+	importNode := node
 
-		if m.ID != "" {
-			loaded.ID = m.ID
+	switch node.Kind {
+	case yaml.ScalarNode:
+		p.Import = node.Value
+	case yaml.MappingNode:
+		b.checkKeys(file, node, profileKeys, "profile")
+		for _, key := range []string{"name", "import"} {
+			if n := mappingValue(node, key); n != nil && n.Kind != yaml.ScalarNode {
+				b.errorf(file, n, "profile %s must be a string", key)
+				return "", nil, "", nil, false
+			}
 		}
-		if m.Type != "" {
-			loaded.Type = m.Type
+		if n := mappingValue(node, "name"); n != nil {
+			p.Name = n.Value
 		}
-		if m.Label != "" {
-			loaded.Label = m.Label
+		if n := mappingValue(node, "import"); n != nil {
+			p.Import = n.Value
+			importNode = n
 		}
-		if m.View != "" {
-			loaded.View = m.View
+		modules = mappingValue(node, "modules")
+	default:
+		b.errorf(file, node, "profile must be a mapping or an import path")
+		return "", nil, "", nil, false
+	}
+
+	if p.Import != "" {
+		importFile, content, importChain, ok := b.loadImport(file, importNode, p.Import, chain)
+		if !ok {
+			return "", nil, "", nil, false
 		}
-		if m.Icon != "" {
-			loaded.Icon = m.Icon
+		name, mods, modFile, modChain, ok := b.resolveProfile(importFile, content, importChain)
+		if !ok {
+			return "", nil, "", nil, false
 		}
-		if m.ConfigAction != "" {
-			loaded.ConfigAction = m.ConfigAction
+		if p.Name != "" {
+			name = p.Name
 		}
-		if m.Source != "" {
-			loaded.Source = m.Source
+		return name, mods, modFile, modChain, true
+	}
+
+	if modules == nil {
+		return p.Name, nil, file, chain, true
+	}
+	if modules.Kind != yaml.SequenceNode {
+		b.errorf(file, modules, "modules must be a list")
+		return "", nil, "", nil, false
+	}
+	return p.Name, modules.Content, file, chain, true
+}
+
+// resolveModule разворачивает import модуля, накладывая поля импортирующего файла поверх импортированных.
+func (b *builder) resolveModule(file string, node *yaml.Node, chain []string) (rawModule, bool) {
+	raw := rawModule{file: file, node: node, childFile: file, childChain: chain}
+	importNode := node
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		raw.Import = node.Value
+	case yaml.MappingNode:
+		b.checkKeys(file, node, moduleKeys, "module")
+		if err := withoutKey(node, "children").Decode((*moduleFields)(&raw.Module)); err != nil {
+			b.diags = append(b.diags, yamlErrorDiags(file, err)...)
+			return rawModule{}, false
+		}
+		if children := mappingValue(node, "children"); children != nil {
+			if children.Kind != yaml.SequenceNode {
+				b.errorf(file, children, "children must be a list")
+				return rawModule{}, false
+			}
+			raw.children = children.Content
 		}
-		if len(m.Children) > 0 {
-			loaded.Children = m.Children
+		if n := mappingValue(node, "import"); n != nil {
+			importNode = n
 		}
+	default:
+		b.errorf(file, node, "module must be a mapping or an import path")
+		return rawModule{}, false
+	}
+
+	if raw.Import == "" {
+		return raw, true
+	}
 
-		return resolveModule(baseDir, loaded, actions)
+	importFile, content, importChain, ok := b.loadImport(file, importNode, raw.Import, chain)
+	if !ok {
+		return rawModule{}, false
+	}
+	loaded, ok := b.resolveModule(importFile, content, importChain)
+	if !ok {
+		return rawModule{}, false
 	}
 
+	m := raw.Module
+	if m.ID != "" {
+		loaded.ID = m.ID
+	}
+	if m.Type != "" {
+		loaded.Type = m.Type
+	}
+	if m.Label != "" {
+		loaded.Label = m.Label
+	}
+	if m.View != "" {
+		loaded.View = m.View
+	}
+	if m.Icon != "" {
+		loaded.Icon = m.Icon
+	}
 	if m.ConfigAction != "" {
+		loaded.ConfigAction = m.ConfigAction
+	}
+	if m.Dispatch != "" {
+		loaded.Dispatch = m.Dispatch
+	}
+	if m.Source != "" {
+		loaded.Source = m.Source
+	}
+	if m.SourceMode != "" {
+		loaded.SourceMode = m.SourceMode
+	}
+	if m.Interval != 0 {
+		loaded.Interval = m.Interval
+	}
+	if m.Timeout != 0 {
+		loaded.Timeout = m.Timeout
+	}
+	if len(raw.children) > 0 {
+		loaded.children = raw.children
+		loaded.childFile = raw.childFile
+		loaded.childChain = raw.childChain
+	}
+	return loaded, true
+}
+
+// finalizeModule проверяет модуль, регистрирует его действие и собирает детей.
+// path — позиция модуля в дереве профилей ("0/2/1"), из неё строится id модулей без явного id.
+func (b *builder) finalizeModule(raw rawModule, path string) (Module, bool) {
+	m := raw.Module
+
+	switch {
+	case m.Type == "":
+		b.errorf(raw.file, raw.node, "module has no type")
+		return Module{}, false
+	case !ModuleTypes[m.Type]:
+		b.errorf(raw.file, valueOr(raw.node, "type"), "unknown module type %q", m.Type)
+		return Module{}, false
+	}
+
+	if m.Type == "slider" && m.ConfigAction != "" && !strings.Contains(m.ConfigAction, "{v}") {
+		b.errorf(raw.file, valueOr(raw.node, "action"), "slider action has no {v} placeholder")
+	}
+	if m.Type == "slider" && m.Dispatch != "" && !strings.Contains(m.Dispatch, "{v}") {
+		b.errorf(raw.file, valueOr(raw.node, "dispatch"), "slider dispatch has no {v} placeholder")
+	}
+	if m.ConfigAction != "" && m.Dispatch != "" {
+		b.errorf(raw.file, valueOr(raw.node, "dispatch"), "module has both action and dispatch")
+	}
+
+	switch m.SourceMode {
+	case "", SourcePoll:
+	case SourceStream, SourceHyprland:
+		if m.Source == "" {
+			b.errorf(raw.file, valueOr(raw.node, "source_mode"), "source_mode %q needs a source", m.SourceMode)
+		}
+		if m.Interval != 0 {
+			b.errorf(raw.file, valueOr(raw.node, "interval"), "interval has no effect with source_mode %q", m.SourceMode)
+		}
+	default:
+		b.errorf(raw.file, valueOr(raw.node, "source_mode"), "unknown source_mode %q (expected %s, %s or %s)",
+			m.SourceMode, SourcePoll, SourceStream, SourceHyprland)
+	}
+
+	if m.Interval != 0 && (m.SourceMode == "" || m.SourceMode == SourcePoll) {
+		switch {
+		case m.Source == "":
+			b.errorf(raw.file, valueOr(raw.node, "interval"), "interval is set but module has no source")
+		case time.Duration(m.Interval) < MinInterval:
+			b.errorf(raw.file, valueOr(raw.node, "interval"), "interval %s is shorter than %s", time.Duration(m.Interval), MinInterval)
+		}
+	}
+
+	if m.ID != "" {
+		where := position(raw.file, valueOr(raw.node, "id"))
+		if first, dup := b.tabIDs[m.ID]; dup {
+			b.errorf(raw.file, valueOr(raw.node, "id"), "duplicate module id %q (first defined at %s)", m.ID, first)
+		} else {
+			b.tabIDs[m.ID] = where
+		}
+		if m.Source != "" {
+			def := sourceDef{source: m.Source, mode: m.SourceMode, interval: m.Interval, timeout: m.Timeout}
+			if prev, ok := b.sources[m.ID]; ok && prev != def {
+				b.errorf(raw.file, valueOr(raw.node, "source"), "module id %q is used with a different source at %s", m.ID, b.sourceAt[m.ID])
+			} else if !ok {
+				b.sources[m.ID] = def
+				b.sourceAt[m.ID] = position(raw.file, valueOr(raw.node, "source"))
+			}
+		}
+	}
+
+	// Без своей команды встроенный media-id — законный способ повесить действие на кнопку
+	if (m.ConfigAction != "" || m.Dispatch != "") && protocol.MediaActions[m.ID] {
+		at := "action"
+		if m.Dispatch != "" {
+			at = "dispatch"
+		}
+		// Сервер выполняет эти id сам, команда модуля никогда бы не запустилась
+		b.errorf(raw.file, valueOr(raw.node, "id"), "module id %q is reserved for built-in media control; drop the %s or rename the module", m.ID, at)
+		return Module{}, false
+	}
+
+	if m.ConfigAction != "" || m.Dispatch != "" {
 		var actionKey string
-		if m.ID != "" {
+		explicitID := m.ID != ""
+		if explicitID {
 			actionKey = m.ID
 		} else {
 			actionKey = fmt.Sprintf("cmd_%x", md5.Sum([]byte(m.ConfigAction)))
+			if m.Dispatch != "" {
+				actionKey = fmt.Sprintf("dispatch_%x", md5.Sum([]byte(m.Dispatch)))
+			}
 			m.ID = actionKey
 		}
-		actions[actionKey] = m.ConfigAction
+		action := Action{Command: m.ConfigAction, Dispatch: m.Dispatch, Timeout: m.Timeout}
+		at := "action"
+		if m.Dispatch != "" {
+			at = "dispatch"
+		}
+		// Один id в разных профилях — одно действие: разные команды под ним
+		// молча заменили бы друг друга
+		if prev, ok := b.actions[actionKey]; ok && prev != action {
+			if explicitID {
+				b.errorf(raw.file, valueOr(raw.node, at), "module id %q is used with a different action at %s", actionKey, b.actionAt[actionKey])
+			} else {
+				b.errorf(raw.file, valueOr(raw.node, "timeout"), "same action as at %s with a different timeout; give one of the modules an id", b.actionAt[actionKey])
+			}
+		} else if !ok {
+			b.actions[actionKey] = action
+			b.actionAt[actionKey] = position(raw.file, valueOr(raw.node, at))
+		}
 		m.Action = actionKey
 	}
 
-	if len(m.Children) > 0 {
-		var resolvedChildren []Module
-		for _, child := range m.Children {
-			res, err := resolveModule(baseDir, child, actions)
-			if err != nil {
-				return Module{}, err
-			}
-			resolvedChildren = append(resolvedChildren, res)
+	m.Children = nil
+	for i, childNode := range raw.children {
+		child, ok := b.resolveModule(raw.childFile, childNode, raw.childChain)
+		if !ok {
+			continue
+		}
+		if res, ok := b.finalizeModule(child, fmt.Sprintf("%s/%d", path, i)); ok {
+			m.Children = append(m.Children, res)
 		}
-		m.Children = resolvedChildren
 	}
 
 	if m.ID == "" {
-		m.ID = "auto_" + GenerateToken()
+		// id зависит только от файла и места в дереве, поэтому хэш конфига стабилен между перезагрузками
+		sum := md5.Sum([]byte(raw.file + "#" + path))
+		m.ID = fmt.Sprintf("auto_%x", sum[:8])
+	}
+
+	return m, true
+}
+
+// loadImport читает импортируемый файл и возвращает продолжённую цепочку import.
+// Ошибки, циклы и превышение глубины привязываются к месту импорта.
+func (b *builder) loadImport(file string, at *yaml.Node, rel string, chain []string) (string, *yaml.Node, []string, bool) {
+	rel = filepath.Clean(rel)
+	next := append(chain[:len(chain):len(chain)], rel)
+
+	for _, seen := range chain {
+		if seen == rel {
+			b.errorf(file, at, "import cycle: %s", strings.Join(next, " -> "))
+			return "", nil, nil, false
+		}
+	}
+	if len(chain) > b.maxDepth {
+		b.errorf(file, at, "import depth limit (%d) exceeded: %s", b.maxDepth, strings.Join(next, " -> "))
+		return "", nil, nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(b.baseDir, rel))
+	if err != nil {
+		if os.IsNotExist(err) {
+			b.errorf(file, at, "import %q: file not found", rel)
+		} else {
+			b.errorf(file, at, "import %q: %v", rel, err)
+		}
+		return "", nil, nil, false
+	}
+	node, err := parseDocument(data)
+	if err != nil {
+		b.diags = append(b.diags, yamlErrorDiags(rel, err)...)
+		return "", nil, nil, false
+	}
+	return rel, node, next, true
+}
+
+func (b *builder) checkKeys(file string, node *yaml.Node, known map[string]bool, what string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !known[key.Value] {
+			b.errorf(file, key, "unknown %s key %q", what, key.Value)
+		}
+	}
+}
+
+func (b *builder) diag(file string, node *yaml.Node, msg string) Diagnostic {
+	return Diagnostic{File: file, Line: node.Line, Column: node.Column, Message: msg}
+}
+
+func (b *builder) errorf(file string, node *yaml.Node, format string, args ...any) {
+	b.diags = append(b.diags, b.diag(file, node, fmt.Sprintf(format, args...)))
+}
+
+func parseDocument(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
 	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Line: 1, Column: 1}, nil
+	}
+	return doc.Content[0], nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// valueOr возвращает значение ключа для точной позиции в диагностике, иначе сам узел.
+func valueOr(node *yaml.Node, key string) *yaml.Node {
+	if v := mappingValue(node, key); v != nil {
+		return v
+	}
+	return node
+}
+
+func withoutKey(node *yaml.Node, key string) *yaml.Node {
+	clone := *node
+	clone.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			clone.Content = append(clone.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+	return &clone
+}
 
-	return m, nil
+func position(file string, node *yaml.Node) string {
+	return fmt.Sprintf("%s:%d:%d", file, node.Line, node.Column)
 }
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSameIDWithDifferentActionsAcrossProfiles(t *testing.T) {
+	dir := writeConfig(t, map[string]string{"main.yaml": `
+profiles:
+  - name: A
+    modules:
+      - {id: power, type: button, action: systemctl poweroff}
+  - name: B
+    modules:
+      - {id: power, type: button, action: systemctl reboot}
+`})
+	bundle, err := BuildFullConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), `module id "power" is used with a different action`) {
+		t.Fatalf("got error %v, want a conflict for power", err)
+	}
+	if got := bundle.Actions["power"].Command; got != "systemctl poweroff" {
+		t.Errorf("power runs %q, want the first definition", got)
+	}
+}
+
+func TestSameIDWithSameActionAcrossProfiles(t *testing.T) {
+	dir := writeConfig(t, map[string]string{"main.yaml": `
+profiles:
+  - name: A
+    modules:
+      - {id: volume, type: slider, action: "wpctl set-volume @DEFAULT_SINK@ {v}%"}
+  - name: B
+    modules:
+      - {id: volume, type: slider, action: "wpctl set-volume @DEFAULT_SINK@ {v}%"}
+`})
+	if _, err := BuildFullConfig(dir); err != nil {
+		t.Fatalf("shared module across profiles: %v", err)
+	}
+}
+
+func TestDerivedActionIDWithDifferentTimeouts(t *testing.T) {
+	dir := writeConfig(t, map[string]string{"main.yaml": `
+profiles:
+  - name: A
+    modules:
+      - {type: button, action: backup.sh, timeout: 10s}
+      - {type: button, action: backup.sh, timeout: 5m}
+`})
+	_, err := BuildFullConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), "different timeout") {
+		t.Fatalf("got error %v, want a timeout conflict", err)
+	}
+}
+
+func TestBuiltinMediaIDWithOwnAction(t *testing.T) {
+	dir := writeConfig(t, map[string]string{"main.yaml": `
+profiles:
+  - name: A
+    modules:
+      - {id: media_next, type: button, action: playerctl next}
+      - {id: media_toggle, type: button}
+      - {id: media_volume, type: slider, action: "wpctl set-volume @DEFAULT_SINK@ {v}%"}
+      - {id: media_art_size, type: button, action: notify-send art}
+`})
+	bundle, err := BuildFullConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), `module id "media_next" is reserved`) {
+		t.Fatalf("got error %v, want media_next rejected", err)
+	}
+	if strings.Count(err.Error(), "reserved") != 1 {
+		t.Errorf("only media_next should be rejected: %v", err)
+	}
+	if _, ok := bundle.Actions["media_volume"]; !ok {
+		t.Error("media_volume is a user action and must stay in the table")
+	}
+	if _, ok := bundle.Actions["media_art_size"]; !ok {
+		t.Error("media_art_size is a message type, not a media action, and must stay in the table")
+	}
+}
+
+func TestSameIDWithDifferentSourcesAcrossProfiles(t *testing.T) {
+	dir := writeConfig(t, map[string]string{"main.yaml": `
+profiles:
+  - name: A
+    modules:
+      - {id: temp, type: display, source: sensors cpu}
+      - {id: load, type: display, source: uptime, interval: 5s}
+  - name: B
+    modules:
+      - {id: temp, type: display, source: sensors gpu}
+      - {id: load, type: display, source: uptime, interval: 5s}
+`})
+	_, err := BuildFullConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), `module id "temp" is used with a different source at main.yaml:5:`) {
+		t.Fatalf("got error %v, want a source conflict for temp", err)
+	}
+	if strings.Contains(err.Error(), `"load"`) {
+		t.Errorf("identical load modules reported as a conflict: %v", err)
+	}
+}
+
+func TestImportCycle(t *testing.T) {
+	dir := writeConfig(t, map[string]string{
+		"main.yaml": "profiles:\n  - p.yaml\n",
+		"p.yaml":    "name: P\nmodules:\n  - a.yaml\n  - {type: button, action: ok.sh}\n",
+		"a.yaml":    "import: b.yaml\n",
+		"b.yaml":    "import: a.yaml\n",
+	})
+	bundle, err := BuildFullConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), "b.yaml:1:9: import cycle: main.yaml -> p.yaml -> a.yaml -> b.yaml -> a.yaml") {
+		t.Fatalf("got error %v, want the cycle reported at the import in b.yaml", err)
+	}
+	// Модули вне цикла собираются как обычно
+	if mods := bundle.UI.Profiles[0].Modules; len(mods) != 1 || mods[0].Type != "button" {
+		t.Errorf("profile modules %+v, want only the button", mods)
+	}
+}
+
+func TestMaxImportDepth(t *testing.T) {
+	files := map[string]string{
+		"p.yaml":  "name: P\nmodules:\n  - m1.yaml\n",
+		"m1.yaml": "import: m2.yaml\n",
+		"m2.yaml": "{type: button, action: ok.sh}\n",
+	}
+	tests := []struct {
+		depth   string
+		wantErr string
+	}{
+		{"", ""},
+		{"3", ""},
+		{"2", "import depth limit (2) exceeded: main.yaml -> p.yaml -> m1.yaml -> m2.yaml"},
+		{"0", "max_import_depth must be a positive integer"},
+	}
+	for _, tt := range tests {
+		t.Run("depth "+tt.depth, func(t *testing.T) {
+			main := "profiles:\n  - p.yaml\n"
+			if tt.depth != "" {
+				main += "max_import_depth: " + tt.depth + "\n"
+			}
+			cfg := map[string]string{"main.yaml": main}
+			for name, content := range files {
+				cfg[name] = content
+			}
+			_, err := BuildFullConfig(writeConfig(t, cfg))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildLeavesConfigDirUntouched(t *testing.T) {
+	dir := writeConfig(t, map[string]string{
+		"main.yaml": "profiles:\n  - name: P\n    modules:\n      - {id: b, type: button, action: ok.sh}\n",
+	})
+	bundle, err := BuildFullConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bundle.Actions["_note"]; ok {
+		t.Error("actions table contains the _note entry")
+	}
+	if len(bundle.Actions) != 1 {
+		t.Errorf("actions table %v, want only b", bundle.Actions)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("config dir has %d entries after build, want only main.yaml", len(entries))
+	}
+}
+
+func TestDumpActionsWritesToStateDir(t *testing.T) {
+	state := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", state)
+	path, err := DumpActions(map[string]Action{"b": {Command: "ok.sh"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(state, "hyprlink", "actions.yaml"); path != want {
+		t.Errorf("dumped to %s, want %s", path, want)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "_note") || !strings.Contains(string(data), "ok.sh") {
+		t.Errorf("dump contents:\n%s", data)
+	}
+}
+
+// moduleIDs — id всех модулей профиля name, включая вложенные.
+func moduleIDs(t *testing.T, ui UIConfig, name string) []string {
+	t.Helper()
+	var ids []string
+	var walk func([]Module)
+	walk = func(mods []Module) {
+		for _, m := range mods {
+			ids = append(ids, m.ID)
+			walk(m.Children)
+		}
+	}
+	for _, tab := range ui.Profiles {
+		if tab.Name == name {
+			walk(tab.Modules)
+			return ids
+		}
+	}
+	t.Fatalf("no profile %q", name)
+	return nil
+}
+
+func TestAutoIDsAndHashAreStable(t *testing.T) {
+	dir := writeConfig(t, map[string]string{
+		"main.yaml": "profiles:\n  - p.yaml\n  - q.yaml\n",
+		"p.yaml":    "name: P\nmodules:\n  - {type: display, source: uptime}\n  - w.yaml\n",
+		"w.yaml":    "{type: display, source: date}\n",
+		"q.yaml":    "name: Q\nmodules:\n  - {type: button, action: b.sh}\n",
+	})
+	first, err := BuildFullConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := BuildFullConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.UI.Hash != second.UI.Hash {
+		t.Errorf("hash changed between builds: %s, %s", first.UI.Hash, second.UI.Hash)
+	}
+	ids := moduleIDs(t, first.UI, "P")
+	for _, id := range ids {
+		if !strings.HasPrefix(id, "auto_") {
+			t.Errorf("id %q, want an auto id", id)
+		}
+	}
+	if again := moduleIDs(t, second.UI, "P"); strings.Join(again, ",") != strings.Join(ids, ",") {
+		t.Errorf("auto ids changed between builds: %v, %v", ids, again)
+	}
+
+	// Правка другого профиля не трогает id модулей P
+	os.WriteFile(filepath.Join(dir, "q.yaml"), []byte("name: Q\nmodules:\n  - {type: button, action: c.sh}\n  - {type: button, action: b.sh}\n"), 0644)
+	edited, err := BuildFullConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.UI.Hash == first.UI.Hash {
+		t.Error("hash did not change after an edit")
+	}
+	if got := moduleIDs(t, edited.UI, "P"); strings.Join(got, ",") != strings.Join(ids, ",") {
+		t.Errorf("editing q.yaml changed auto ids in P: %v, was %v", got, ids)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		name    string
+		modules string
+		want    string
+	}{
+		{
+			"unknown type",
+			"      - {type: knob}\n",
+			`main.yaml:4:16: unknown module type "knob"`,
+		},
+		{
+			"unknown key",
+			"      - {type: button, action: a.sh, colour: red}\n",
+			`main.yaml:4:38: unknown module key "colour"`,
+		},
+		{
+			"missing import",
+			"      - widgets/missing.yaml\n",
+			`main.yaml:4:9: import "widgets/missing.yaml": file not found`,
+		},
+		{
+			"duplicate id",
+			"      - {id: cpu, type: display, source: cpu.sh}\n      - {id: cpu, type: display, source: cpu.sh}\n",
+			`main.yaml:5:14: duplicate module id "cpu" (first defined at main.yaml:4:14)`,
+		},
+		{
+			"slider without placeholder",
+			"      - {type: slider, action: wpctl set-volume @DEFAULT_SINK@ 50%}\n",
+			`main.yaml:4:32: slider action has no {v} placeholder`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeConfig(t, map[string]string{
+				"main.yaml": "profiles:\n  - name: P\n    modules:\n" + tt.modules,
+			})
+			_, err := BuildFullConfig(dir)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
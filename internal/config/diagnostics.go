@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Diagnostic — одна проблема в конфиге с привязкой к файлу и позиции в YAML.
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	switch {
+	case d.Line > 0 && d.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+	case d.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	default:
+		return fmt.Sprintf("%s: %s", d.File, d.Message)
+	}
+}
+
+// Diagnostics возвращается из BuildFullConfig как ошибка, если конфиг собрался не полностью.
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.String()
+	}
+	return fmt.Sprintf("%d config problem(s):\n%s", len(ds), strings.Join(lines, "\n"))
+}
+
+// ModuleTypes — типы модулей, которые умеет рисовать клиент.
+var ModuleTypes = map[string]bool{
+	"row":     true,
+	"column":  true,
+	"button":  true,
+	"slider":  true,
+	"display": true,
+	"toggle":  true,
+}
+
+var (
+	mainKeys    = yamlKeys(reflect.TypeOf(MainConfig{}))
+	profileKeys = yamlKeys(reflect.TypeOf(Profile{}))
+	moduleKeys  = yamlKeys(reflect.TypeOf(Module{}))
+
+	clipboardKeys        = yamlKeys(reflect.TypeOf(ClipboardConfig{}))
+	connectionKeys       = yamlKeys(reflect.TypeOf(ConnectionConfig{}))
+	notificationKeys     = yamlKeys(reflect.TypeOf(NotificationsConfig{}))
+	notificationRuleKeys = yamlKeys(reflect.TypeOf(NotificationRule{}))
+)
+
+// yamlKeys собирает допустимые ключи из yaml-тегов, чтобы новые поля структур
+// автоматически считались известными.
+func yamlKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		keys[name] = true
+	}
+	return keys
+}
+
+var yamlLineRe = regexp.MustCompile(`line (\d+)`)
+
+// yamlErrorDiags превращает ошибку yaml.v3 в диагностики, вытаскивая номер строки из текста.
+func yamlErrorDiags(file string, err error) Diagnostics {
+	msgs := []string{err.Error()}
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	}
+	var ds Diagnostics
+	for _, msg := range msgs {
+		d := Diagnostic{File: file, Message: strings.TrimPrefix(msg, "yaml: ")}
+		if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			d.Message = strings.TrimPrefix(strings.TrimPrefix(d.Message, m[0]), ": ")
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StateDir — каталог для служебных файлов HyprLink ($XDG_STATE_HOME/hyprlink).
+// Конфиг пользователя мы туда, где он его редактирует, не пишем.
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "hyprlink"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "hyprlink"), nil
+}
+
+// DumpActions записывает сгенерированную таблицу действий в StateDir для отладки и возвращает путь к файлу.
+func DumpActions(actions map[string]Action) (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	data, err := yaml.Marshal(actions)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "actions.yaml")
+	return path, os.WriteFile(path, data, 0600)
+}
@@ -2,30 +2,233 @@ package config
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// TrustedDevicesFile — имя файла с доверенными устройствами в каталоге конфига.
+const TrustedDevicesFile = "trusted_devices.json"
+
+var ErrUnknownDevice = errors.New("unknown device")
+
 func GenerateToken() string {
 	b := make([]byte, 32)
 	rand.Read(b)
 	return hex.EncodeToString(b)
 }
 
-func SaveTrustedDevice(path string, device TrustedDevice) error {
-	devices, _ := LoadTrustedDevices(path)
-	devices[device.ID] = device
-	data, _ := json.MarshalIndent(devices, "", "  ")
-	return os.WriteFile(path, data, 0644)
+func hashToken(salt, token string) string {
+	sum := sha256.Sum256([]byte(salt + token))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyToken сравнивает предъявленный токен с сохранённым хэшем за постоянное время.
+func (d TrustedDevice) VerifyToken(token string) bool {
+	if d.TokenHash == "" || token == "" {
+		return false
+	}
+	want := []byte(d.TokenHash)
+	got := []byte(hashToken(d.Salt, token))
+	return subtle.ConstantTimeCompare(want, got) == 1
+}
+
+// TrustedStore хранит доверенные устройства в JSON-файле; токены лежат в виде солёных хэшей.
+// Файл правят и сервер, и hyprlink -mode devices, поэтому изменения идут под flock.
+type TrustedStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewTrustedStore(path string) *TrustedStore {
+	return &TrustedStore{path: path}
+}
+
+func (s *TrustedStore) Path() string {
+	return s.path
+}
+
+// List возвращает все устройства. Отсутствующий файл — это пустой список.
+func (s *TrustedStore) List() (map[string]TrustedDevice, error) {
+	var devices map[string]TrustedDevice
+	err := s.update(func(d map[string]TrustedDevice) (bool, error) {
+		devices = d
+		return false, nil
+	})
+	return devices, err
+}
+
+// Get возвращает устройство по id.
+func (s *TrustedStore) Get(id string) (TrustedDevice, bool) {
+	devices, err := s.List()
+	if err != nil {
+		return TrustedDevice{}, false
+	}
+	dev, ok := devices[id]
+	return dev, ok
+}
+
+// Verify проверяет токен устройства.
+func (s *TrustedStore) Verify(id, token string) bool {
+	dev, ok := s.Get(id)
+	return ok && dev.VerifyToken(token)
+}
+
+// Add сохраняет новое устройство; сам токен на диск не попадает.
+func (s *TrustedStore) Add(id, name, token, ip string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	now := time.Now()
+	dev := TrustedDevice{
+		ID:        id,
+		Name:      name,
+		Salt:      hex.EncodeToString(salt),
+		CreatedAt: now,
+		LastSeen:  now,
+		LastIP:    ip,
+	}
+	dev.TokenHash = hashToken(dev.Salt, token)
+	return s.update(func(d map[string]TrustedDevice) (bool, error) {
+		d[id] = dev
+		return true, nil
+	})
+}
+
+// Touch обновляет время и адрес последнего подключения.
+func (s *TrustedStore) Touch(id, ip string) error {
+	return s.modify(id, func(dev *TrustedDevice) {
+		dev.LastSeen = time.Now()
+		dev.LastIP = ip
+	})
+}
+
+func (s *TrustedStore) Rename(id, name string) error {
+	return s.modify(id, func(dev *TrustedDevice) {
+		dev.Name = name
+	})
 }
 
-func LoadTrustedDevices(path string) (map[string]TrustedDevice, error) {
+// SetMediaPlayer запоминает выбранный на устройстве плеер.
+func (s *TrustedStore) SetMediaPlayer(id, player string) error {
+	return s.modify(id, func(dev *TrustedDevice) {
+		dev.MediaPlayer = player
+	})
+}
+
+// Revoke удаляет устройство; его токен больше не принимается.
+func (s *TrustedStore) Revoke(id string) error {
+	return s.update(func(d map[string]TrustedDevice) (bool, error) {
+		if _, ok := d[id]; !ok {
+			return false, ErrUnknownDevice
+		}
+		delete(d, id)
+		return true, nil
+	})
+}
+
+func (s *TrustedStore) modify(id string, fn func(*TrustedDevice)) error {
+	return s.update(func(d map[string]TrustedDevice) (bool, error) {
+		dev, ok := d[id]
+		if !ok {
+			return false, ErrUnknownDevice
+		}
+		fn(&dev)
+		d[id] = dev
+		return true, nil
+	})
+}
+
+// update читает файл под блокировкой, даёт fn изменить карту и, если fn вернул true, сохраняет её.
+// Записи в старом формате с открытым токеном при этом переводятся на хэши.
+func (s *TrustedStore) update(fn func(map[string]TrustedDevice) (bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
 	devices := make(map[string]TrustedDevice)
-	data, err := os.ReadFile(path)
+	migrated := false
+	data, err := os.ReadFile(s.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &devices); err != nil {
+			return err
+		}
+		for id, dev := range devices {
+			if dev.Token == "" {
+				continue
+			}
+			salt := make([]byte, 16)
+			rand.Read(salt)
+			dev.Salt = hex.EncodeToString(salt)
+			dev.TokenHash = hashToken(dev.Salt, dev.Token)
+			dev.Token = ""
+			if dev.ID == "" {
+				dev.ID = id
+			}
+			devices[id] = dev
+			migrated = true
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	changed, err := fn(devices)
 	if err != nil {
-		return devices, err
+		return err
+	}
+	if !changed && !migrated {
+		return nil
+	}
+	return writeFileAtomic(s.path, devices)
+}
+
+func writeFileAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Имя с точкой в начале, чтобы временный файл не будил вотчер конфига
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
 	}
-	json.Unmarshal(data, &devices)
-	return devices, nil
+	return os.Rename(tmp.Name(), path)
 }
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newStore(t *testing.T) *TrustedStore {
+	t.Helper()
+	return NewTrustedStore(filepath.Join(t.TempDir(), TrustedDevicesFile))
+}
+
+func TestTrustedStoreKeepsOnlyHashes(t *testing.T) {
+	store := newStore(t)
+	if err := store.Add("phone-1", "Pixel", "secret-token", "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-token") {
+		t.Errorf("token stored in plain text:\n%s", data)
+	}
+	st, err := os.Stat(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0600 {
+		t.Errorf("file mode %v, want 0600", st.Mode().Perm())
+	}
+
+	// Одинаковые токены у разных устройств дают разные хэши благодаря соли
+	store.Add("phone-2", "Tablet", "secret-token", "10.0.0.3")
+	a, _ := store.Get("phone-1")
+	b, _ := store.Get("phone-2")
+	if a.TokenHash == b.TokenHash {
+		t.Error("same token hashed to the same value for two devices")
+	}
+}
+
+func TestTrustedStoreVerify(t *testing.T) {
+	store := newStore(t)
+	store.Add("phone-1", "Pixel", "secret-token", "10.0.0.2")
+
+	tests := []struct {
+		name      string
+		id, token string
+		want      bool
+	}{
+		{"right token", "phone-1", "secret-token", true},
+		{"wrong token", "phone-1", "other-token", false},
+		{"empty token", "phone-1", "", false},
+		{"unknown device", "phone-2", "secret-token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := store.Verify(tt.id, tt.token); got != tt.want {
+				t.Errorf("Verify(%q, %q) = %v, want %v", tt.id, tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedStoreMigratesPlainTokens(t *testing.T) {
+	store := newStore(t)
+	legacy := `{"phone-old": {"name": "Old Phone", "token": "legacy-token"}}`
+	if err := os.WriteFile(store.Path(), []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if !store.Verify("phone-old", "legacy-token") {
+		t.Fatal("legacy token is not accepted after migration")
+	}
+	data, _ := os.ReadFile(store.Path())
+	if strings.Contains(string(data), "legacy-token") {
+		t.Errorf("plain token left in the file after migration:\n%s", data)
+	}
+	dev, _ := store.Get("phone-old")
+	if dev.ID != "phone-old" || dev.Name != "Old Phone" || dev.Token != "" {
+		t.Errorf("migrated device %+v", dev)
+	}
+}
+
+func TestTrustedStoreChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*TrustedStore) error
+		wantErr error
+		check   func(*testing.T, *TrustedStore)
+	}{
+		{
+			name:   "rename",
+			change: func(s *TrustedStore) error { return s.Rename("phone-1", "Work Phone") },
+			check: func(t *testing.T, s *TrustedStore) {
+				if dev, _ := s.Get("phone-1"); dev.Name != "Work Phone" {
+					t.Errorf("name %q, want Work Phone", dev.Name)
+				}
+				if !s.Verify("phone-1", "secret-token") {
+					t.Error("rename broke the token")
+				}
+			},
+		},
+		{
+			name:   "revoke",
+			change: func(s *TrustedStore) error { return s.Revoke("phone-1") },
+			check: func(t *testing.T, s *TrustedStore) {
+				if _, ok := s.Get("phone-1"); ok {
+					t.Error("revoked device is still listed")
+				}
+				if s.Verify("phone-1", "secret-token") {
+					t.Error("revoked device token is still accepted")
+				}
+			},
+		},
+		{
+			name:    "rename unknown",
+			change:  func(s *TrustedStore) error { return s.Rename("phone-2", "Nobody") },
+			wantErr: ErrUnknownDevice,
+		},
+		{
+			name:    "revoke unknown",
+			change:  func(s *TrustedStore) error { return s.Revoke("phone-2") },
+			wantErr: ErrUnknownDevice,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStore(t)
+			store.Add("phone-1", "Pixel", "secret-token", "10.0.0.2")
+			if err := tt.change(store); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, store)
+			}
+		})
+	}
+}
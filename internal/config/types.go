@@ -1,10 +1,137 @@
 package config
 
-import "gopkg.in/yaml.v3"
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
 
 type MainConfig struct {
 	Hostname string    `yaml:"hostname"`
 	Profiles []Profile `yaml:"profiles"`
+	// MaxImportDepth ограничивает вложенность import (по умолчанию DefaultMaxImportDepth).
+	MaxImportDepth int `yaml:"max_import_depth,omitempty"`
+	// Clipboard настраивает синхронизацию буфера обмена.
+	Clipboard ClipboardConfig `yaml:"clipboard,omitempty"`
+	// Notifications настраивает уведомления в обе стороны.
+	Notifications NotificationsConfig `yaml:"notifications,omitempty"`
+	// Connection настраивает проверку связи с телефонами.
+	Connection ConnectionConfig `yaml:"connection,omitempty"`
+}
+
+// NotificationsConfig — секция notifications в main.yaml.
+type NotificationsConfig struct {
+	// Mute — уведомления с телефона, подходящие под любое правило, не показываются.
+	Mute []NotificationRule `yaml:"mute,omitempty"`
+	// Forward — уведомления компьютера, которые пересылаются на телефоны. Пусто — никакие.
+	Forward []NotificationRule `yaml:"forward,omitempty"`
+	// HideWhenLocked скрывает заголовок и текст пересылаемых уведомлений, пока экран заблокирован.
+	HideWhenLocked bool `yaml:"hide_when_locked,omitempty"`
+}
+
+// NotificationRule сравнивает уведомление с шаблонами path.Match без учёта регистра.
+// Пустой шаблон подходит под всё, но хотя бы один должен быть задан.
+type NotificationRule struct {
+	App   string `yaml:"app,omitempty"`
+	Title string `yaml:"title,omitempty"`
+}
+
+// Matches проверяет, подходит ли уведомление под правило.
+func (r NotificationRule) Matches(app, title string) bool {
+	return globMatch(r.App, app) && globMatch(r.Title, title)
+}
+
+// Muted — подходит ли уведомление под одно из правил mute.
+func (c NotificationsConfig) Muted(app, title string) bool {
+	for _, r := range c.Mute {
+		if r.Matches(app, title) {
+			return true
+		}
+	}
+	return false
+}
+
+// Forwarded — нужно ли пересылать уведомление компьютера на телефоны.
+func (c NotificationsConfig) Forwarded(app, title string) bool {
+	for _, r := range c.Forward {
+		if r.Matches(app, title) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(s))
+	return ok
+}
+
+// Значения секции connection по умолчанию.
+const (
+	DefaultHeartbeat     = 15 * time.Second
+	DefaultClientTimeout = 45 * time.Second
+	DefaultResumeWindow  = 60 * time.Second
+)
+
+// ConnectionConfig — секция connection в main.yaml.
+type ConnectionConfig struct {
+	// Heartbeat — как часто сервер пингует телефон.
+	Heartbeat Duration `yaml:"heartbeat,omitempty"`
+	// Timeout — через сколько без сообщений от телефона соединение считается мёртвым.
+	Timeout Duration `yaml:"timeout,omitempty"`
+	// Resume — сколько после обрыва телефон может продолжить сессию без повторной отправки конфига.
+	Resume Duration `yaml:"resume,omitempty"`
+}
+
+// ClipboardConfig — секция clipboard в main.yaml.
+type ClipboardConfig struct {
+	// Policy действует для устройств, которых нет в Devices: both (по умолчанию), push, pull или off.
+	Policy string `yaml:"policy,omitempty"`
+	// Devices задаёт политику для отдельных устройств по id.
+	Devices map[string]string `yaml:"devices,omitempty"`
+	// MaxSize — предел размера содержимого в байтах, по умолчанию DefaultClipboardMaxSize.
+	MaxSize int `yaml:"max_size,omitempty"`
+	// History — сколько последних записей помнить, чтобы не пересылать повторы и эхо.
+	History int `yaml:"history,omitempty"`
+}
+
+const (
+	// ClipboardPush — только с телефона на компьютер.
+	ClipboardPush = "push"
+	// ClipboardPull — только с компьютера на телефон.
+	ClipboardPull = "pull"
+	ClipboardBoth = "both"
+	ClipboardOff  = "off"
+)
+
+// PolicyFor возвращает политику буфера обмена для устройства.
+func (c ClipboardConfig) PolicyFor(deviceID string) string {
+	if p, ok := c.Devices[deviceID]; ok {
+		return p
+	}
+	if c.Policy == "" {
+		return ClipboardBoth
+	}
+	return c.Policy
+}
+
+// AllowsPush — можно ли принимать буфер обмена с устройства.
+func (c ClipboardConfig) AllowsPush(deviceID string) bool {
+	p := c.PolicyFor(deviceID)
+	return p == ClipboardBoth || p == ClipboardPush
+}
+
+// AllowsPull — можно ли отправлять устройству буфер обмена компьютера.
+func (c ClipboardConfig) AllowsPull(deviceID string) bool {
+	p := c.PolicyFor(deviceID)
+	return p == ClipboardBoth || p == ClipboardPull
 }
 
 type Profile struct {
@@ -44,9 +171,33 @@ type Module struct {
 
 	Action       string `json:"action,omitempty" yaml:"-"`
 	ConfigAction string `json:"-" yaml:"action,omitempty"`
+	// Dispatch — dispatcher Hyprland, который выполняется вместо action без запуска hyprctl.
+	Dispatch string `json:"-" yaml:"dispatch,omitempty"`
 
 	Source string `json:"-" yaml:"source,omitempty"`
-	Import string `json:"-" yaml:"import,omitempty"`
+	// SourceMode — SourcePoll (по умолчанию), SourceStream или SourceHyprland.
+	SourceMode string `json:"-" yaml:"source_mode,omitempty"`
+	Import     string `json:"-" yaml:"import,omitempty"`
+	// Interval — период опроса source, по умолчанию DefaultInterval.
+	Interval Duration `json:"-" yaml:"interval,omitempty"`
+	// Timeout ограничивает время работы source и action этого модуля.
+	Timeout Duration `json:"-" yaml:"timeout,omitempty"`
+}
+
+const (
+	// SourcePoll — source запускается каждые interval, значение — весь его вывод.
+	SourcePoll = "poll"
+	// SourceStream — source запускается один раз, каждая строка вывода — новое значение.
+	SourceStream = "stream"
+	// SourceHyprland — source это имя события Hyprland (workspace, activewindow...), значение — его данные.
+	SourceHyprland = "hyprland"
+)
+
+// Action — команда из таблицы действий, которую запускает сервер по id модуля.
+type Action struct {
+	Command  string   `json:"command" yaml:"command"`
+	Dispatch string   `json:"dispatch,omitempty" yaml:"dispatch,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
 }
 
 func (m *Module) UnmarshalYAML(value *yaml.Node) error {
@@ -58,8 +209,48 @@ func (m *Module) UnmarshalYAML(value *yaml.Node) error {
 	return value.Decode((*alias)(m))
 }
 
+// Duration — time.Duration, который в YAML записывается строкой: "500ms", "10s", "1m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var raw string
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil || parsed <= 0 {
+		return fmt.Errorf("line %d: invalid duration %q (expected e.g. 500ms, 10s, 1m)", value.Line, raw)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Or возвращает d или def, если d не задан.
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
 type TrustedDevice struct {
-	ID    string `yaml:"id"`
-	Token string `yaml:"token"`
-	Name  string `yaml:"name"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	TokenHash string    `json:"token_hash"`
+	Salt      string    `json:"salt"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	LastIP    string    `json:"last_ip,omitempty"`
+	// MediaPlayer — MPRIS-плеер, который устройство выбрало последним.
+	MediaPlayer string `json:"media_player,omitempty"`
+	// Token — открытый токен из старых версий файла, при загрузке заменяется на хэш.
+	Token string `json:"token,omitempty"`
 }
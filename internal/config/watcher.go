@@ -29,7 +29,8 @@ func WatchConfig(basePath string, onWrite func()) {
 				}
 
 				name := filepath.Base(event.Name)
-				if name == "actions.json" || name == "actions.yaml" || strings.HasPrefix(name, ".") {
+				// Доверенные устройства — не часть UI, за ними следит сервер
+				if strings.HasPrefix(name, ".") || name == TrustedDevicesFile {
 					continue
 				}
 
@@ -0,0 +1,172 @@
+// Package hyprland общается с Hyprland напрямую через его unix-сокеты, без запуска hyprctl.
+package hyprland
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// RequestSocket принимает команды hyprctl: dispatch, j/activewindow и т.п.
+	RequestSocket = ".socket.sock"
+	// EventSocket отдаёт поток событий в виде строк EVENT>>DATA.
+	EventSocket = ".socket2.sock"
+)
+
+var ErrNotRunning = errors.New("hyprland: HYPRLAND_INSTANCE_SIGNATURE is not set")
+
+// Event — одно событие из .socket2.sock, например {workspace, 3} или {activewindow, kitty,~}.
+type Event struct {
+	Name string
+	Data string
+}
+
+// Client — подключение к одному экземпляру Hyprland. Dir — каталог с его сокетами.
+type Client struct {
+	Dir string
+}
+
+// FromEnv находит сокеты текущей сессии по HYPRLAND_INSTANCE_SIGNATURE.
+func FromEnv() (*Client, error) {
+	sig := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	if sig == "" {
+		return nil, ErrNotRunning
+	}
+	if runtime := os.Getenv("XDG_RUNTIME_DIR"); runtime != "" {
+		dir := filepath.Join(runtime, "hypr", sig)
+		if _, err := os.Stat(filepath.Join(dir, EventSocket)); err == nil {
+			return &Client{Dir: dir}, nil
+		}
+	}
+	// Hyprland до 0.40 держал сокеты в /tmp
+	return &Client{Dir: filepath.Join("/tmp", "hypr", sig)}, nil
+}
+
+// Request отправляет одну команду в .socket.sock и возвращает ответ целиком.
+func (c *Client) Request(ctx context.Context, command string) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", filepath.Join(c.Dir, RequestSocket))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := io.WriteString(conn, command); err != nil {
+		return nil, err
+	}
+	// Hyprland отвечает и сам закрывает соединение
+	return io.ReadAll(conn)
+}
+
+// Dispatch выполняет dispatcher, например "workspace 3" или "exec kitty".
+func (c *Client) Dispatch(ctx context.Context, args string) error {
+	reply, err := c.Request(ctx, "dispatch "+args)
+	if err != nil {
+		return err
+	}
+	if msg := strings.TrimSpace(string(reply)); msg != "ok" {
+		return fmt.Errorf("dispatch %s: %s", args, msg)
+	}
+	return nil
+}
+
+// Subscribe читает события, пока не закроется сокет или не отменится ctx.
+func (c *Client) Subscribe(ctx context.Context, onEvent func(Event)) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", filepath.Join(c.Dir, EventSocket))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		name, data, ok := strings.Cut(scanner.Text(), ">>")
+		if !ok {
+			continue
+		}
+		onEvent(Event{Name: name, Data: data})
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// Current запрашивает текущее значение для событий, которые иначе пришли бы только при следующем
+// изменении. Данные в том же формате, что и у события; ok=false, если событие не поддерживается.
+func (c *Client) Current(ctx context.Context, event string) (string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	switch event {
+	case "workspace", "workspacev2":
+		var ws struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		}
+		if !c.requestJSON(ctx, "j/activeworkspace", &ws) {
+			return "", false
+		}
+		if event == "workspacev2" {
+			return fmt.Sprintf("%d,%s", ws.ID, ws.Name), true
+		}
+		return ws.Name, true
+	case "activewindow":
+		var win struct {
+			Class string `json:"class"`
+			Title string `json:"title"`
+		}
+		if !c.requestJSON(ctx, "j/activewindow", &win) {
+			return "", false
+		}
+		if win.Class == "" && win.Title == "" {
+			return ",", true
+		}
+		return win.Class + "," + win.Title, true
+	case "activelayout":
+		var devices struct {
+			Keyboards []struct {
+				Name         string `json:"name"`
+				ActiveKeymap string `json:"active_keymap"`
+				Main         bool   `json:"main"`
+			} `json:"keyboards"`
+		}
+		if !c.requestJSON(ctx, "j/devices", &devices) {
+			return "", false
+		}
+		for _, kb := range devices.Keyboards {
+			if kb.Main {
+				return kb.Name + "," + kb.ActiveKeymap, true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
+func (c *Client) requestJSON(ctx context.Context, command string, v any) bool {
+	reply, err := c.Request(ctx, command)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(reply, v) == nil
+}
@@ -0,0 +1,156 @@
+// Package hyprlandtest поднимает поддельный Hyprland на unix-сокетах для тестов и отладки.
+package hyprlandtest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/hyprland"
+)
+
+// Fake слушает .socket.sock и .socket2.sock в Dir. События из Emit и Replay получают
+// все подписчики, а запросы к .socket.sock запоминаются и отвечаются через Handler.
+type Fake struct {
+	Dir string
+	// Handler отвечает на запрос к .socket.sock; по умолчанию "ok" на dispatch и "unknown request" на остальное.
+	Handler func(request string) string
+
+	mu          sync.Mutex
+	requests    []string
+	subscribers map[net.Conn]struct{}
+	listeners   []net.Listener
+	wg          sync.WaitGroup
+}
+
+// Start создаёт сокеты в dir (обычно t.TempDir()).
+func Start(dir string) (*Fake, error) {
+	f := &Fake{Dir: dir, subscribers: make(map[net.Conn]struct{})}
+	for _, name := range []string{hyprland.RequestSocket, hyprland.EventSocket} {
+		path := filepath.Join(dir, name)
+		os.Remove(path)
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.listeners = append(f.listeners, ln)
+		f.wg.Add(1)
+		go f.accept(ln, name == hyprland.EventSocket)
+	}
+	return f, nil
+}
+
+// Client возвращает клиента, подключённого к этому Fake.
+func (f *Fake) Client() *hyprland.Client {
+	return &hyprland.Client{Dir: f.Dir}
+}
+
+// Emit отправляет событие всем подписчикам.
+func (f *Fake) Emit(name, data string) {
+	line := name + ">>" + data + "\n"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.subscribers {
+		if _, err := io.WriteString(conn, line); err != nil {
+			conn.Close()
+			delete(f.subscribers, conn)
+		}
+	}
+}
+
+// Replay проигрывает записанный поток событий (вывод socat от .socket2.sock), по строке EVENT>>DATA.
+// delay — пауза между событиями.
+func (f *Fake) Replay(r io.Reader, delay time.Duration) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name, data, ok := strings.Cut(scanner.Text(), ">>")
+		if !ok {
+			continue
+		}
+		f.Emit(name, data)
+		time.Sleep(delay)
+	}
+	return scanner.Err()
+}
+
+// Subscribers — сколько клиентов сейчас слушает события.
+func (f *Fake) Subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers)
+}
+
+// DropSubscribers разрывает соединения подписчиков, как при перезапуске Hyprland.
+func (f *Fake) DropSubscribers() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.subscribers {
+		conn.Close()
+		delete(f.subscribers, conn)
+	}
+}
+
+// Requests возвращает копию всех запросов к .socket.sock.
+func (f *Fake) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+func (f *Fake) Close() {
+	f.mu.Lock()
+	for _, ln := range f.listeners {
+		ln.Close()
+	}
+	for conn := range f.subscribers {
+		conn.Close()
+		delete(f.subscribers, conn)
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+}
+
+func (f *Fake) accept(ln net.Listener, events bool) {
+	defer f.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if events {
+			f.mu.Lock()
+			f.subscribers[conn] = struct{}{}
+			f.mu.Unlock()
+			continue
+		}
+		go f.serveRequest(conn)
+	}
+}
+
+func (f *Fake) serveRequest(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 8192)
+	n, _ := conn.Read(buf)
+	request := string(buf[:n])
+
+	f.mu.Lock()
+	f.requests = append(f.requests, request)
+	handler := f.Handler
+	f.mu.Unlock()
+
+	reply := "unknown request"
+	if handler != nil {
+		reply = handler(request)
+	} else if strings.HasPrefix(request, "dispatch ") {
+		reply = "ok"
+	}
+	fmt.Fprint(conn, reply)
+}
@@ -0,0 +1,147 @@
+package media
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	// Декодеры форматов, в которых плееры кладут обложки
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	MinArtSize = 32
+	MaxArtSize = 1024
+	// maxArtFile отсекает случайно указанные огромные файлы до декодирования.
+	maxArtFile = 20 << 20
+	// maxArtPixels ограничивает размер картинки: маленький PNG может распаковаться в гигабайты.
+	maxArtPixels = 4096 * 4096
+)
+
+// artRetry — через сколько повторять неудачную загрузку: файл обложки мог ещё не дописаться.
+var artRetry = 10 * time.Second
+
+// LoadArt читает обложку по file:// URL и возвращает JPEG, большая сторона которого не больше size.
+func LoadArt(artURL string, size int) ([]byte, error) {
+	u, err := url.Parse(artURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "file" {
+		return nil, fmt.Errorf("art: unsupported url scheme %q", u.Scheme)
+	}
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err == nil && st.Size() > maxArtFile {
+		return nil, fmt.Errorf("art: %s is too large (%d bytes)", u.Path, st.Size())
+	}
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("art: %s: %w", u.Path, err)
+	}
+	if cfg.Width*cfg.Height > maxArtPixels {
+		return nil, fmt.Errorf("art: %s is too large (%dx%d)", u.Path, cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("art: %s: %w", u.Path, err)
+	}
+	size = min(max(size, MinArtSize), MaxArtSize)
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ArtCache хранит последние уменьшенные обложки, чтобы не декодировать файл на каждое
+// изменение статуса плеера. Ключ — URL и размер.
+type ArtCache struct {
+	limit int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[artKey]*list.Element
+}
+
+type artKey struct {
+	url  string
+	size int
+}
+
+type artEntry struct {
+	key  artKey
+	data []byte
+	err  error
+	// failed — когда загрузка не удалась; после artRetry запись считается устаревшей.
+	failed time.Time
+}
+
+func NewArtCache(limit int) *ArtCache {
+	return &ArtCache{limit: limit, order: list.New(), entries: make(map[artKey]*list.Element)}
+}
+
+// Get возвращает обложку из кэша или загружает её через LoadArt. Ошибки кэшируются на artRetry.
+func (c *ArtCache) Get(artURL string, size int) ([]byte, error) {
+	key := artKey{artURL, size}
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*artEntry)
+		if e.err == nil || time.Since(e.failed) < artRetry {
+			c.order.MoveToFront(el)
+			c.mu.Unlock()
+			return e.data, e.err
+		}
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+
+	data, err := LoadArt(artURL, size)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		e := &artEntry{key: key, data: data, err: err}
+		if err != nil {
+			e.failed = time.Now()
+		}
+		c.entries[key] = c.order.PushFront(e)
+		for c.order.Len() > c.limit {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*artEntry).key)
+		}
+	}
+	return data, err
+}
@@ -0,0 +1,51 @@
+package media
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writePNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadArtRejectsHugeImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "huge.png")
+	writePNG(t, path, 8192, 4096)
+	if _, err := LoadArt("file://"+path, 256); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("got %v, want a size error", err)
+	}
+}
+
+func TestArtCacheRetriesFailedLoad(t *testing.T) {
+	defer func(old time.Duration) { artRetry = old }(artRetry)
+	artRetry = 50 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "cover.png")
+	cache := NewArtCache(4)
+	if _, err := cache.Get("file://"+path, 64); err == nil {
+		t.Fatal("missing file loaded")
+	}
+	// Плеер записал файл после того, как сообщил art_url
+	writePNG(t, path, 300, 300)
+	if _, err := cache.Get("file://"+path, 64); err == nil {
+		t.Fatal("error was not cached at all")
+	}
+	time.Sleep(artRetry)
+	if data, err := cache.Get("file://"+path, 64); err != nil || len(data) == 0 {
+		t.Fatalf("after artRetry: %v", err)
+	}
+}
@@ -0,0 +1,431 @@
+// Package media следит за MPRIS-плеерами на сессионной шине D-Bus и управляет ими.
+package media
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	busPrefix   = "org.mpris.MediaPlayer2."
+	objectPath  = dbus.ObjectPath("/org/mpris/MediaPlayer2")
+	rootIface   = "org.mpris.MediaPlayer2"
+	playerIface = "org.mpris.MediaPlayer2.Player"
+	propsIface  = "org.freedesktop.DBus.Properties"
+)
+
+// Player — состояние одного плеера. Position — позиция на момент Updated;
+// текущую с учётом Rate считает PositionNow.
+type Player struct {
+	// Name — имя шины без префикса org.mpris.MediaPlayer2., например spotify.
+	Name     string
+	Identity string
+	// Status — playing, paused или stopped.
+	Status   string
+	Title    string
+	Artist   string
+	Album    string
+	ArtURL   string
+	TrackID  dbus.ObjectPath
+	Length   time.Duration
+	Position time.Duration
+	Updated  time.Time
+	Rate     float64
+	Shuffle  bool
+	// Loop — none, track или playlist.
+	Loop string
+}
+
+// PositionNow интерполирует позицию играющего трека от последнего известного значения.
+func (p Player) PositionNow() time.Duration {
+	pos := p.Position
+	if p.Status == "playing" {
+		pos += time.Duration(float64(time.Since(p.Updated)) * p.Rate)
+	}
+	if p.Length > 0 && pos > p.Length {
+		pos = p.Length
+	}
+	return pos
+}
+
+// Watcher держит актуальный список плееров по сигналам PropertiesChanged, Seeked
+// и NameOwnerChanged. onChange вызывается после каждого изменения из горутины Run.
+type Watcher struct {
+	conn     *dbus.Conn
+	onChange func()
+
+	mu      sync.Mutex
+	players map[string]*Player
+	// owners сопоставляет уникальное имя отправителя (:1.42) с именем плеера:
+	// сигналы приходят от уникального имени, а не от org.mpris.MediaPlayer2.*
+	owners map[string]string
+}
+
+func NewWatcher(conn *dbus.Conn, onChange func()) *Watcher {
+	return &Watcher{
+		conn:     conn,
+		onChange: onChange,
+		players:  make(map[string]*Player),
+		owners:   make(map[string]string),
+	}
+}
+
+// Run подписывается на сигналы, загружает уже запущенные плееры и обрабатывает
+// изменения до отмены ctx или закрытия соединения.
+func (w *Watcher) Run(ctx context.Context) error {
+	matches := [][]dbus.MatchOption{
+		{dbus.WithMatchObjectPath(objectPath), dbus.WithMatchInterface(propsIface), dbus.WithMatchMember("PropertiesChanged")},
+		{dbus.WithMatchObjectPath(objectPath), dbus.WithMatchInterface(playerIface), dbus.WithMatchMember("Seeked")},
+		{dbus.WithMatchSender("org.freedesktop.DBus"), dbus.WithMatchInterface("org.freedesktop.DBus"),
+			dbus.WithMatchMember("NameOwnerChanged"), dbus.WithMatchArg0Namespace(strings.TrimSuffix(busPrefix, "."))},
+	}
+	for _, m := range matches {
+		if err := w.conn.AddMatchSignalContext(ctx, m...); err != nil {
+			return fmt.Errorf("mpris: add match: %w", err)
+		}
+	}
+	signals := make(chan *dbus.Signal, 64)
+	w.conn.Signal(signals)
+	defer w.conn.RemoveSignal(signals)
+
+	var names []string
+	if err := w.conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.ListNames", 0).Store(&names); err != nil {
+		return fmt.Errorf("mpris: list names: %w", err)
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, busPrefix) {
+			w.addPlayer(ctx, name)
+		}
+	}
+	w.onChange()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sig, ok := <-signals:
+			if !ok {
+				return fmt.Errorf("mpris: connection closed")
+			}
+			if w.handleSignal(ctx, sig) {
+				w.onChange()
+			}
+		}
+	}
+}
+
+// Players возвращает копии всех плееров, отсортированные по имени.
+func (w *Watcher) Players() []Player {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]Player, 0, len(w.players))
+	for _, p := range w.players {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Active выбирает плеер для управления: preferred, если он есть, иначе первый играющий,
+// иначе первый по имени. ok=false, если плееров нет.
+func (w *Watcher) Active(preferred string) (Player, bool) {
+	players := w.Players()
+	if len(players) == 0 {
+		return Player{}, false
+	}
+	for _, p := range players {
+		if p.Name == preferred {
+			return p, true
+		}
+	}
+	for _, p := range players {
+		if p.Status == "playing" {
+			return p, true
+		}
+	}
+	return players[0], true
+}
+
+// Call вызывает метод org.mpris.MediaPlayer2.Player: Play, Pause, PlayPause, Next, Previous.
+func (w *Watcher) Call(ctx context.Context, player, method string, args ...interface{}) error {
+	return w.object(player).CallWithContext(ctx, playerIface+"."+method, 0, args...).Err
+}
+
+// SetPosition перематывает текущий трек плеера на pos.
+func (w *Watcher) SetPosition(ctx context.Context, player string, pos time.Duration) error {
+	w.mu.Lock()
+	p, ok := w.players[busPrefix+player]
+	var track dbus.ObjectPath
+	if ok {
+		track = p.TrackID
+	}
+	w.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("mpris: no player %q", player)
+	}
+	if track == "" {
+		// Без mpris:trackid SetPosition не работает, остаётся относительный Seek
+		return w.Call(ctx, player, "Seek", (pos - p.PositionNow()).Microseconds())
+	}
+	return w.Call(ctx, player, "SetPosition", track, pos.Microseconds())
+}
+
+// SetShuffle включает или выключает случайный порядок.
+func (w *Watcher) SetShuffle(ctx context.Context, player string, on bool) error {
+	return w.setProperty(ctx, player, "Shuffle", on)
+}
+
+// SetLoop задаёт режим повтора: none, track или playlist.
+func (w *Watcher) SetLoop(ctx context.Context, player, loop string) error {
+	var status string
+	switch loop {
+	case "none":
+		status = "None"
+	case "track":
+		status = "Track"
+	case "playlist":
+		status = "Playlist"
+	default:
+		return fmt.Errorf("mpris: unknown loop status %q", loop)
+	}
+	return w.setProperty(ctx, player, "LoopStatus", status)
+}
+
+func (w *Watcher) setProperty(ctx context.Context, player, name string, value interface{}) error {
+	return w.object(player).CallWithContext(ctx, propsIface+".Set", 0, playerIface, name, dbus.MakeVariant(value)).Err
+}
+
+func (w *Watcher) object(player string) dbus.BusObject {
+	return w.conn.Object(busPrefix+player, objectPath)
+}
+
+// handleSignal применяет сигнал к состоянию; true, если что-то изменилось.
+func (w *Watcher) handleSignal(ctx context.Context, sig *dbus.Signal) bool {
+	switch sig.Name {
+	case "org.freedesktop.DBus.NameOwnerChanged":
+		var name, oldOwner, newOwner string
+		if dbus.Store(sig.Body, &name, &oldOwner, &newOwner) != nil || !strings.HasPrefix(name, busPrefix) {
+			return false
+		}
+		if newOwner == "" {
+			w.removePlayer(name)
+		} else {
+			w.addPlayer(ctx, name)
+		}
+		return true
+
+	case propsIface + ".PropertiesChanged":
+		var iface string
+		var changed map[string]dbus.Variant
+		var invalidated []string
+		if dbus.Store(sig.Body, &iface, &changed, &invalidated) != nil {
+			return false
+		}
+		name := w.playerBySender(sig.Sender)
+		if name == "" {
+			return false
+		}
+		switch iface {
+		case playerIface:
+			if len(invalidated) > 0 {
+				// Плеер не прислал значения, перечитываем всё
+				w.loadPlayer(ctx, name)
+				return true
+			}
+			// Position не сигналится, но после смены трека, статуса или скорости её нужно перечитать
+			pos, posErr := w.readPosition(ctx, name)
+			w.mu.Lock()
+			if p, ok := w.players[name]; ok {
+				applyPlayerProps(p, changed)
+				if posErr == nil {
+					p.Position, p.Updated = pos, time.Now()
+				}
+			}
+			w.mu.Unlock()
+			return true
+		case rootIface:
+			if v, ok := changed["Identity"]; ok {
+				w.mu.Lock()
+				if p, ok := w.players[name]; ok {
+					p.Identity, _ = v.Value().(string)
+				}
+				w.mu.Unlock()
+				return true
+			}
+		}
+		return false
+
+	case playerIface + ".Seeked":
+		var us int64
+		if dbus.Store(sig.Body, &us) != nil {
+			return false
+		}
+		name := w.playerBySender(sig.Sender)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		p, ok := w.players[name]
+		if !ok {
+			return false
+		}
+		p.Position, p.Updated = time.Duration(us)*time.Microsecond, time.Now()
+		return true
+	}
+	return false
+}
+
+func (w *Watcher) playerBySender(sender string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.players[sender]; ok {
+		return sender
+	}
+	return w.owners[sender]
+}
+
+// addPlayer читает свойства плеера и только потом добавляет его: до этого клиенты
+// увидели бы плеер без названия и трека в состоянии stopped.
+func (w *Watcher) addPlayer(ctx context.Context, name string) {
+	var owner string
+	if err := w.conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.GetNameOwner", 0, name).Store(&owner); err != nil {
+		return
+	}
+	p := w.readPlayer(ctx, name)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for unique, n := range w.owners {
+		if n == name {
+			delete(w.owners, unique)
+		}
+	}
+	w.owners[owner] = name
+	w.players[name] = p
+}
+
+func (w *Watcher) removePlayer(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.players, name)
+	for unique, n := range w.owners {
+		if n == name {
+			delete(w.owners, unique)
+		}
+	}
+}
+
+// loadPlayer перечитывает все свойства уже известного плеера.
+func (w *Watcher) loadPlayer(ctx context.Context, name string) {
+	p := w.readPlayer(ctx, name)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.players[name]; ok {
+		w.players[name] = p
+	}
+}
+
+// readPlayer читает все свойства плеера с шины. Ошибки не фатальны: многие плееры
+// реализуют MPRIS частично, тогда остаются значения по умолчанию.
+func (w *Watcher) readPlayer(ctx context.Context, name string) *Player {
+	obj := w.conn.Object(name, objectPath)
+	var root, props map[string]dbus.Variant
+	obj.CallWithContext(ctx, propsIface+".GetAll", 0, rootIface).Store(&root)
+	obj.CallWithContext(ctx, propsIface+".GetAll", 0, playerIface).Store(&props)
+
+	p := &Player{Name: strings.TrimPrefix(name, busPrefix), Status: "stopped", Rate: 1, Loop: "none", Updated: time.Now()}
+	if v, ok := root["Identity"]; ok {
+		p.Identity, _ = v.Value().(string)
+	}
+	applyPlayerProps(p, props)
+	if v, ok := props["Position"]; ok {
+		p.Position = time.Duration(toInt64(v.Value())) * time.Microsecond
+	}
+	return p
+}
+
+func (w *Watcher) readPosition(ctx context.Context, name string) (time.Duration, error) {
+	var v dbus.Variant
+	err := w.conn.Object(name, objectPath).CallWithContext(ctx, propsIface+".Get", 0, playerIface, "Position").Store(&v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(toInt64(v.Value())) * time.Microsecond, nil
+}
+
+func applyPlayerProps(p *Player, props map[string]dbus.Variant) {
+	for key, v := range props {
+		switch key {
+		case "PlaybackStatus":
+			status, _ := v.Value().(string)
+			if status != "" && p.Status == "playing" && strings.ToLower(status) != "playing" {
+				// Фиксируем позицию, до которой трек доиграл
+				p.Position, p.Updated = p.PositionNow(), time.Now()
+			}
+			p.Status = strings.ToLower(status)
+		case "Rate":
+			if rate, ok := v.Value().(float64); ok {
+				p.Rate = rate
+			}
+		case "Shuffle":
+			p.Shuffle, _ = v.Value().(bool)
+		case "LoopStatus":
+			loop, _ := v.Value().(string)
+			p.Loop = strings.ToLower(loop)
+		case "Metadata":
+			meta, _ := v.Value().(map[string]dbus.Variant)
+			applyMetadata(p, meta)
+		}
+	}
+}
+
+func applyMetadata(p *Player, meta map[string]dbus.Variant) {
+	p.Title, p.Artist, p.Album, p.ArtURL, p.TrackID, p.Length = "", "", "", "", "", 0
+	for key, v := range meta {
+		switch key {
+		case "xesam:title":
+			p.Title, _ = v.Value().(string)
+		case "xesam:artist":
+			// По спецификации это список, но некоторые плееры шлют строку
+			switch a := v.Value().(type) {
+			case []string:
+				p.Artist = strings.Join(a, ", ")
+			case string:
+				p.Artist = a
+			}
+		case "xesam:album":
+			p.Album, _ = v.Value().(string)
+		case "mpris:artUrl":
+			p.ArtURL, _ = v.Value().(string)
+		case "mpris:trackid":
+			switch id := v.Value().(type) {
+			case dbus.ObjectPath:
+				p.TrackID = id
+			case string:
+				p.TrackID = dbus.ObjectPath(id)
+			}
+		case "mpris:length":
+			p.Length = time.Duration(toInt64(v.Value())) * time.Microsecond
+		}
+	}
+}
+
+// toInt64 нужен потому, что плееры шлют длину и позицию как x, t, i или u.
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
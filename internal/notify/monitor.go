@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Observed — вызов Notify, подсмотренный на шине.
+type Observed struct {
+	// Sender — уникальное имя отправителя, по нему можно отсеять свои же уведомления.
+	Sender     string
+	AppName    string
+	ReplacesID uint32
+	Summary    string
+	Body       string
+	Urgency    byte
+}
+
+// Monitor превращает conn в монитор шины и вызывает onNotify для каждого вызова Notify
+// до отмены ctx. После этого conn годится только для мониторинга; закрывает его вызывающий.
+func Monitor(ctx context.Context, conn *dbus.Conn, onNotify func(Observed)) error {
+	rule := fmt.Sprintf("type='method_call',interface='%s',member='Notify'", iface)
+	call := conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.Monitoring.BecomeMonitor", 0, []string{rule}, uint32(0))
+	if call.Err != nil {
+		return fmt.Errorf("notify: become monitor: %w", call.Err)
+	}
+	// Сообщения монитору адресованы не ему, обычная диспетчеризация godbus их отбросит
+	messages := make(chan *dbus.Message, 32)
+	conn.Eavesdrop(messages)
+	// Close закрывает канал Eavesdrop, пока godbus ещё может в него писать, поэтому
+	// перед возвратом канал отвязывается
+	defer conn.Eavesdrop(nil)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return fmt.Errorf("notify: monitor connection closed")
+			}
+			if n, ok := parseNotify(msg); ok {
+				onNotify(n)
+			}
+		}
+	}
+}
+
+func parseNotify(msg *dbus.Message) (Observed, bool) {
+	if msg.Type != dbus.TypeMethodCall {
+		return Observed{}, false
+	}
+	member, _ := msg.Headers[dbus.FieldMember].Value().(string)
+	msgIface, _ := msg.Headers[dbus.FieldInterface].Value().(string)
+	if member != "Notify" || msgIface != iface || len(msg.Body) < 8 {
+		return Observed{}, false
+	}
+
+	var n Observed
+	n.Sender, _ = msg.Headers[dbus.FieldSender].Value().(string)
+	n.AppName, _ = msg.Body[0].(string)
+	n.ReplacesID, _ = msg.Body[1].(uint32)
+	n.Summary, _ = msg.Body[3].(string)
+	n.Body, _ = msg.Body[4].(string)
+	n.Urgency = UrgencyNormal
+	if hints, ok := msg.Body[6].(map[string]dbus.Variant); ok {
+		if u, ok := hints["urgency"].Value().(byte); ok {
+			n.Urgency = u
+		}
+	}
+	return n, true
+}
@@ -0,0 +1,180 @@
+// Package notify показывает уведомления через org.freedesktop.Notifications и
+// сообщает о нажатых в них кнопках.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/godbus/dbus/v5"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	busName    = "org.freedesktop.Notifications"
+	objectPath = dbus.ObjectPath("/org/freedesktop/Notifications")
+	iface      = "org.freedesktop.Notifications"
+
+	// maxIconSize — большая сторона иконки; демоны уведомлений всё равно рисуют их мелко.
+	maxIconSize = 128
+	// maxIconPixels ограничивает исходную картинку до декодирования: маленький PNG
+	// может распаковаться в гигабайты.
+	maxIconPixels = 2048 * 2048
+)
+
+// Уровни срочности из спецификации Desktop Notifications.
+const (
+	UrgencyLow      byte = 0
+	UrgencyNormal   byte = 1
+	UrgencyCritical byte = 2
+)
+
+// Action — кнопка уведомления. Key "default" срабатывает при клике по самому уведомлению.
+type Action struct {
+	Key   string
+	Label string
+}
+
+// Notification — одно уведомление для Notify.
+type Notification struct {
+	AppName string
+	// ReplacesID — id уведомления, которое нужно обновить вместо показа нового.
+	ReplacesID uint32
+	Summary    string
+	Body       string
+	Actions    []Action
+	Urgency    byte
+	// Icon — PNG или JPEG; передаётся демону как image-data.
+	Icon []byte
+	// Timeout в миллисекундах; -1 — на усмотрение демона.
+	Timeout int32
+}
+
+// imageData — формат подсказки image-data: (iiibiiay).
+type imageData struct {
+	Width         int32
+	Height        int32
+	RowStride     int32
+	HasAlpha      bool
+	BitsPerSample int32
+	Channels      int32
+	Data          []byte
+}
+
+// Client отправляет уведомления и получает сигналы о действиях и закрытии.
+type Client struct {
+	conn *dbus.Conn
+	// OnAction вызывается, когда пользователь нажал кнопку в уведомлении id.
+	OnAction func(id uint32, key string)
+	// OnClosed вызывается, когда уведомление id закрыто.
+	OnClosed func(id uint32)
+}
+
+func NewClient(conn *dbus.Conn) *Client {
+	return &Client{conn: conn}
+}
+
+// Notify показывает уведомление и возвращает его id.
+func (c *Client) Notify(ctx context.Context, n Notification) (uint32, error) {
+	actions := make([]string, 0, len(n.Actions)*2)
+	for _, a := range n.Actions {
+		actions = append(actions, a.Key, a.Label)
+	}
+	hints := map[string]dbus.Variant{
+		"urgency": dbus.MakeVariant(n.Urgency),
+	}
+	if len(n.Icon) > 0 {
+		img, err := decodeIcon(n.Icon)
+		if err != nil {
+			return 0, err
+		}
+		hints["image-data"] = dbus.MakeVariant(img)
+	}
+
+	var id uint32
+	err := c.conn.Object(busName, objectPath).CallWithContext(ctx, iface+".Notify", 0,
+		n.AppName, n.ReplacesID, "", n.Summary, n.Body, actions, hints, n.Timeout).Store(&id)
+	return id, err
+}
+
+// Run получает ActionInvoked и NotificationClosed до отмены ctx.
+func (c *Client) Run(ctx context.Context) error {
+	for _, member := range []string{"ActionInvoked", "NotificationClosed"} {
+		err := c.conn.AddMatchSignalContext(ctx,
+			dbus.WithMatchObjectPath(objectPath), dbus.WithMatchInterface(iface), dbus.WithMatchMember(member))
+		if err != nil {
+			return fmt.Errorf("notify: add match: %w", err)
+		}
+	}
+	signals := make(chan *dbus.Signal, 16)
+	c.conn.Signal(signals)
+	defer c.conn.RemoveSignal(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sig, ok := <-signals:
+			if !ok {
+				return fmt.Errorf("notify: connection closed")
+			}
+			switch sig.Name {
+			case iface + ".ActionInvoked":
+				var id uint32
+				var key string
+				if dbus.Store(sig.Body, &id, &key) == nil && c.OnAction != nil {
+					c.OnAction(id, key)
+				}
+			case iface + ".NotificationClosed":
+				var id, reason uint32
+				if dbus.Store(sig.Body, &id, &reason) == nil && c.OnClosed != nil {
+					c.OnClosed(id)
+				}
+			}
+		}
+	}
+}
+
+// decodeIcon переводит картинку в RGBA без предумножения альфы, как требует image-data.
+func decodeIcon(data []byte) (imageData, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return imageData{}, fmt.Errorf("notify: icon: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxIconPixels {
+		return imageData{}, fmt.Errorf("notify: icon is too large (%dx%d)", cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return imageData{}, fmt.Errorf("notify: icon: %w", err)
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > maxIconSize || h > maxIconSize {
+		if w >= h {
+			w, h = maxIconSize, max(1, h*maxIconSize/w)
+		} else {
+			w, h = max(1, w*maxIconSize/h), maxIconSize
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if w == b.Dx() && h == b.Dy() {
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	} else {
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	}
+	return imageData{
+		Width:         int32(w),
+		Height:        int32(h),
+		RowStride:     int32(dst.Stride),
+		HasAlpha:      true,
+		BitsPerSample: 8,
+		Channels:      4,
+		Data:          dst.Pix,
+	}, nil
+}
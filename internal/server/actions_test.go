@@ -0,0 +1,133 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/Monekx/hyprlink/internal/server/servertest"
+)
+
+func TestSliderActionRunsCommandWithValue(t *testing.T) {
+	bundle := &config.ConfigBundle{
+		Settings: config.DefaultSettings(),
+		Actions: map[string]config.Action{
+			"volume": {Command: "wpctl set-volume @DEFAULT_AUDIO_SINK@ {v}%"},
+		},
+	}
+	s, runner := startServer(t, server.Options{}, bundle)
+	p, _ := connect(t, s, nil)
+
+	p.send(map[string]interface{}{"type": "action", "id": "volume", "value": 42})
+	cmd, ok := runner.WaitFor("wpctl", 2*time.Second)
+	if !ok {
+		t.Fatal("action did not run")
+	}
+	if got, want := cmd.String(), "wpctl set-volume @DEFAULT_AUDIO_SINK@ 42%"; !strings.Contains(got, want) {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+}
+
+func TestUnknownActionRunsNothing(t *testing.T) {
+	s, runner := startServer(t, server.Options{}, &config.ConfigBundle{Settings: config.DefaultSettings()})
+	p, _ := connect(t, s, nil)
+
+	p.send(map[string]interface{}{"type": "action", "id": "rm -rf /", "value": 1})
+	if cmd, ok := runner.WaitFor("rm", 300*time.Millisecond); ok {
+		t.Errorf("unknown action ran %q", cmd)
+	}
+}
+
+func TestUserMediaPrefixedActionRunsCommand(t *testing.T) {
+	bundle := &config.ConfigBundle{
+		Settings: config.DefaultSettings(),
+		Actions: map[string]config.Action{
+			"media_volume": {Command: "wpctl set-volume @DEFAULT_AUDIO_SINK@ {v}%"},
+		},
+	}
+	s, runner := startServer(t, server.Options{}, bundle)
+	p, _ := connect(t, s, nil)
+
+	p.send(map[string]interface{}{"type": "action", "id": "media_volume", "value": 30})
+	if _, ok := runner.WaitFor("wpctl", 2*time.Second); !ok {
+		t.Fatal("media_volume was taken for a built-in media action")
+	}
+}
+
+// actionError ждёт error для действия id.
+func actionError(t *testing.T, p *phone, id string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		msg := p.expect("error", time.Until(deadline))
+		if msg["id"] == id {
+			msg, _ := msg["message"].(string)
+			return msg
+		}
+	}
+}
+
+func TestActionTimeoutReportsError(t *testing.T) {
+	bundle := &config.ConfigBundle{
+		Settings: config.DefaultSettings(),
+		Actions: map[string]config.Action{
+			"backup": {Command: "backup.sh", Timeout: config.Duration(50 * time.Millisecond)},
+			"quick":  {Command: "quick.sh", Timeout: config.Duration(time.Second)},
+		},
+	}
+	runner := &servertest.RecordingRunner{Handler: func(ctx context.Context, cmd server.Cmd) ([]byte, error) {
+		if strings.Contains(cmd.String(), "backup.sh") {
+			// Зависшая команда: ExecRunner убил бы её по отмене контекста
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	}}
+	s, _ := startServer(t, server.Options{Runner: runner, MaxCommands: 1}, bundle)
+	p, _ := connect(t, s, hello())
+
+	p.send(map[string]interface{}{"type": "action", "id": "backup"})
+	if msg := actionError(t, p, "backup"); !strings.Contains(msg, "timed out after 50ms") {
+		t.Errorf("error %q, want a timeout", msg)
+	}
+	// Единственный слот освободился вместе с убитой командой
+	p.send(map[string]interface{}{"type": "action", "id": "quick"})
+	if _, ok := runner.WaitFor("quick.sh", 500*time.Millisecond); !ok {
+		t.Error("slot of the timed out action was not freed")
+	}
+}
+
+func TestActionWaitsForFreeSlot(t *testing.T) {
+	bundle := &config.ConfigBundle{
+		Settings: config.DefaultSettings(),
+		Actions: map[string]config.Action{
+			"slow":  {Command: "slow.sh"},
+			"quick": {Command: "quick.sh", Timeout: config.Duration(100 * time.Millisecond)},
+		},
+	}
+	release := make(chan struct{})
+	defer close(release)
+	runner := &servertest.RecordingRunner{Handler: func(_ context.Context, cmd server.Cmd) ([]byte, error) {
+		if strings.Contains(cmd.String(), "slow.sh") {
+			<-release
+		}
+		return nil, nil
+	}}
+	s, _ := startServer(t, server.Options{Runner: runner, MaxCommands: 1}, bundle)
+	p, _ := connect(t, s, hello())
+
+	p.send(map[string]interface{}{"type": "action", "id": "slow"})
+	if _, ok := runner.WaitFor("slow.sh", 2*time.Second); !ok {
+		t.Fatal("slow action did not start")
+	}
+	p.send(map[string]interface{}{"type": "action", "id": "quick"})
+	if msg := actionError(t, p, "quick"); !strings.Contains(msg, "no free command slot") {
+		t.Errorf("error %q, want no free slot", msg)
+	}
+	if _, ok := runner.WaitFor("quick.sh", 0); ok {
+		t.Error("quick action ran while the only slot was taken")
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/protocol"
+)
+
+const (
+	mimeText    = "text/plain"
+	mimeURIList = "text/uri-list"
+	mimePNG     = "image/png"
+
+	clipboardTimeout = 5 * time.Second
+	// clipboardEchoWindow — сколько после отправки или получения записи её повтор от
+	// устройства считается эхом, а не новым копированием.
+	clipboardEchoWindow = 10 * time.Second
+)
+
+// clipboardHistory помнит хэши последних записей буфера обмена, не больше clipboard.history,
+// чтобы не принимать от устройства эхо только что полученной или отправленной им записи.
+type clipboardHistory struct {
+	mu      sync.Mutex
+	entries []clipEntry
+}
+
+type clipEntry struct {
+	mime string
+	sum  [sha256.Size]byte
+	// origin — устройство, приславшее запись, или пустая строка, если её скопировали на компьютере.
+	origin string
+	// sent — запись уже разослана устройствам.
+	sent bool
+	// at — когда запись последний раз пришла от устройства или ушла устройствам.
+	at time.Time
+}
+
+// find — индекс записи в истории или -1. Вызывается под h.mu.
+func (h *clipboardHistory) find(mime string, sum [sha256.Size]byte) int {
+	for i, e := range h.entries {
+		if e.mime == mime && e.sum == sum {
+			return i
+		}
+	}
+	return -1
+}
+
+// pushed запоминает запись, присланную устройством origin; false, если она уже в буфере
+// обмена или это эхо записи из истории, которая была у origin в последние clipboardEchoWindow.
+func (h *clipboardHistory) pushed(origin, mime string, data []byte, limit int) bool {
+	sum := sha256.Sum256(data)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch i := h.find(mime, sum); {
+	case i == 0:
+		return false
+	case i > 0:
+		e := h.entries[i]
+		if (e.origin == origin || e.sent) && time.Since(e.at) < clipboardEchoWindow {
+			return false
+		}
+	}
+	h.toFront(clipEntry{mime: mime, sum: sum, origin: origin, at: time.Now()}, limit)
+	return true
+}
+
+// observed вызывается, когда буфер обмена компьютера изменился. send=false, если эту запись уже
+// разослали; origin — устройство, от которого она пришла, ему её возвращать не нужно.
+func (h *clipboardHistory) observed(mime string, data []byte, limit int) (origin string, send bool) {
+	sum := sha256.Sum256(data)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.find(mime, sum) == 0 {
+		front := &h.entries[0]
+		if front.sent {
+			return "", false
+		}
+		front.sent, front.at = true, time.Now()
+		return front.origin, true
+	}
+	// Новая запись или запись из истории, скопированная заново на компьютере: её получают все
+	h.toFront(clipEntry{mime: mime, sum: sum, sent: true, at: time.Now()}, limit)
+	return "", true
+}
+
+func (h *clipboardHistory) toFront(e clipEntry, limit int) {
+	if i := h.find(e.mime, e.sum); i >= 0 {
+		h.entries = append(h.entries[:i], h.entries[i+1:]...)
+	}
+	h.entries = append([]clipEntry{e}, h.entries...)
+	if limit > 0 && len(h.entries) > limit {
+		h.entries = h.entries[:limit]
+	}
+}
+
+// handleClipboard ставит в буфер обмена компьютера содержимое, присланное телефоном.
+// Текст приходит как есть, image/png — в base64; без mime это старый клиент с текстом.
+func (s *Server) handleClipboard(c *client, data map[string]interface{}) {
+	settings := s.currentSettings().Clipboard
+	if !settings.AllowsPush(c.deviceID) {
+		fmt.Printf("Clipboard from %s ignored by policy %s\n", c.deviceID, settings.PolicyFor(c.deviceID))
+		return
+	}
+
+	mime, _ := data["mime"].(string)
+	content, _ := data["content"].(string)
+	var payload []byte
+	switch mime {
+	case "", mimeText, mimeURIList:
+		if strings.TrimSpace(content) == "" {
+			return
+		}
+		if mime == "" {
+			mime = mimeText
+		}
+		payload = []byte(content)
+	case mimePNG:
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			fmt.Printf("Clipboard from %s: bad image data: %v\n", c.deviceID, err)
+			return
+		}
+		payload = decoded
+	default:
+		fmt.Printf("Clipboard from %s: unsupported type %s\n", c.deviceID, mime)
+		return
+	}
+	if len(payload) > settings.MaxSize {
+		fmt.Printf("Clipboard from %s: %d bytes exceeds max_size %d\n", c.deviceID, len(payload), settings.MaxSize)
+		return
+	}
+	if !s.clipHistory.pushed(c.deviceID, mime, payload, settings.History) {
+		return
+	}
+
+	// Данные идут в stdin wl-copy без shell, поэтому кавычки и юникод не портятся
+	cmd := Command("wl-copy", "--type", clipboardCopyType(mime))
+	cmd.Stdin = payload
+	if _, err := s.runLimited(cmd, clipboardTimeout); err != nil {
+		fmt.Printf("Clipboard: wl-copy failed: %v\n", err)
+	}
+}
+
+// clipboardCopyType — тип, под которым wl-copy предложит текст приложениям.
+func clipboardCopyType(mime string) string {
+	if mime == mimeText {
+		return "text/plain;charset=utf-8"
+	}
+	return mime
+}
+
+// readClipboard читает буфер обмена компьютера в самом подходящем из поддерживаемых типов.
+// ok=false, если буфер пуст, тип не поддерживается или содержимое больше maxSize.
+func (s *Server) readClipboard(maxSize int) (mime string, data []byte, ok bool) {
+	types, err := s.output(Command("wl-paste", "--list-types"))
+	if err != nil {
+		return "", nil, false
+	}
+	offered := make(map[string]bool)
+	for _, t := range strings.Split(string(types), "\n") {
+		offered[strings.TrimSpace(t)] = true
+	}
+
+	var pasteType string
+	switch {
+	case offered[mimePNG]:
+		mime, pasteType = mimePNG, mimePNG
+	case offered[mimeURIList]:
+		mime, pasteType = mimeURIList, mimeURIList
+	case offered["text/plain;charset=utf-8"]:
+		mime, pasteType = mimeText, "text/plain;charset=utf-8"
+	case offered[mimeText], offered["UTF8_STRING"], offered["TEXT"], offered["STRING"]:
+		mime, pasteType = mimeText, "text"
+	default:
+		return "", nil, false
+	}
+
+	data, err = s.output(Command("wl-paste", "--no-newline", "--type", pasteType))
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return "", nil, false
+	}
+	if len(data) > maxSize {
+		fmt.Printf("Clipboard: %d bytes of %s exceeds max_size %d, not sent\n", len(data), mime, maxSize)
+		return "", nil, false
+	}
+	return mime, data, true
+}
+
+// watchClipboard ждёт изменений буфера обмена через wl-paste --watch, который печатает
+// пустую строку на каждую смену; само содержимое читается отдельно.
+func (s *Server) watchClipboard() {
+	watch := Command("wl-paste", "--watch", "sh", "-c", "cat >/dev/null; echo")
+	restartWithBackoff(s.ctx, func() error {
+		return s.runner.Stream(s.ctx, watch, func(string) {
+			s.clipboardChanged()
+		})
+	}, func(err error, delay time.Duration) {
+		fmt.Printf("Clipboard: wl-paste --watch exited (%v), restarting in %s\n", err, delay)
+	})
+}
+
+func (s *Server) clipboardChanged() {
+	settings := s.currentSettings().Clipboard
+	mime, data, ok := s.readClipboard(settings.MaxSize)
+	if !ok {
+		return
+	}
+	if origin, send := s.clipHistory.observed(mime, data, settings.History); send {
+		s.sendClipboard(settings, origin, mime, data)
+	}
+}
+
+// sendClipboard рассылает буфер обмена устройствам, чья политика разрешает pull,
+// кроме origin — устройства, с которого это содержимое пришло.
+func (s *Server) sendClipboard(settings config.ClipboardConfig, origin, mime string, data []byte) {
+	resp := Response{Type: protocol.TypeClipboard, Mime: mime}
+	if mime == mimePNG {
+		resp.Content = base64.StdEncoding.EncodeToString(data)
+	} else {
+		resp.Content = string(data)
+	}
+	s.broadcastEach(func(c *client) (Response, bool) {
+		// Клиенты версии 0 знают только текст в content
+		if c.version < 1 && mime == mimePNG {
+			return resp, false
+		}
+		return resp, c.deviceID != origin && settings.AllowsPull(c.deviceID)
+	})
+}
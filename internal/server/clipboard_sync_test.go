@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/Monekx/hyprlink/internal/server/servertest"
+)
+
+func TestClipboardFromPhoneGoesToWlCopyStdin(t *testing.T) {
+	s, runner := startServer(t, server.Options{}, nil)
+	p, _ := connect(t, s, hello())
+
+	text := `echo "it's" $HOME; привет 🙂`
+	p.send(map[string]interface{}{"type": "clipboard", "mime": "text/plain", "content": text})
+	cmd, ok := runner.WaitFor("wl-copy", 2*time.Second)
+	if !ok {
+		t.Fatal("wl-copy was not run")
+	}
+	if cmd.Name != "wl-copy" || strings.Join(cmd.Args, " ") != "--type text/plain;charset=utf-8" {
+		t.Errorf("ran %q, want wl-copy --type text/plain;charset=utf-8 without a shell", cmd)
+	}
+	if string(cmd.Stdin) != text {
+		t.Errorf("wl-copy stdin %q, want %q", cmd.Stdin, text)
+	}
+}
+
+func TestClipboardChangeReachesOtherDevices(t *testing.T) {
+	signal := make(chan struct{})
+	runner := &servertest.RecordingRunner{
+		Handler: func(_ context.Context, cmd server.Cmd) ([]byte, error) {
+			switch cmd.String() {
+			case "wl-paste --list-types":
+				return []byte("text/plain;charset=utf-8\n"), nil
+			case "wl-paste --no-newline --type text/plain;charset=utf-8":
+				return []byte("copied"), nil
+			}
+			return nil, nil
+		},
+		StreamHandler: func(ctx context.Context, cmd server.Cmd, onLine func(string)) error {
+			select {
+			case <-signal:
+				onLine("")
+			case <-ctx.Done():
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	_, first, second := twoPhones(t, server.Options{Runner: runner}, "clipboard")
+
+	// Содержимое пришло с первого телефона: wl-paste --watch сообщит о нём, но вернуть его первому не нужно
+	first.send(map[string]interface{}{"type": "clipboard", "content": "copied"})
+	if _, ok := runner.WaitFor("wl-copy", 2*time.Second); !ok {
+		t.Fatal("wl-copy was not run")
+	}
+	close(signal)
+
+	if msg := second.expect("clipboard", 2*time.Second); msg["content"] != "copied" || msg["mime"] != "text/plain" {
+		t.Errorf("phone-two got %v, want the copied text", msg)
+	}
+	for {
+		msg, ok := first.next(300 * time.Millisecond)
+		if !ok {
+			break
+		}
+		if msg["type"] == "clipboard" {
+			t.Errorf("clipboard was echoed back to its origin: %v", msg)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import "testing"
+
+func TestClipboardHistoryDropsEchoFromHistory(t *testing.T) {
+	var h clipboardHistory
+	const limit = 3
+
+	// Телефон A прислал X, компьютер разослал его остальным
+	if !h.pushed("a", mimeText, []byte("x"), limit) {
+		t.Fatal("first push of x dropped")
+	}
+	if origin, send := h.observed(mimeText, []byte("x"), limit); !send || origin != "a" {
+		t.Fatalf("observed x = %q, %v, want a, true", origin, send)
+	}
+	// Телефон B прислал Y раньше, чем его приложение вернуло полученный X
+	if !h.pushed("b", mimeText, []byte("y"), limit) {
+		t.Fatal("push of y dropped")
+	}
+	h.observed(mimeText, []byte("y"), limit)
+	if h.pushed("b", mimeText, []byte("x"), limit) {
+		t.Error("echo of x from b was accepted, it is not the front entry but is in history")
+	}
+	if h.pushed("a", mimeText, []byte("y"), limit) {
+		t.Error("echo of y from a was accepted")
+	}
+
+	// Скопированное заново на компьютере получают все
+	if _, send := h.observed(mimeText, []byte("x"), limit); !send {
+		t.Error("x copied again on the computer was not sent")
+	}
+}
+
+func TestClipboardHistoryForgetsBeyondLimit(t *testing.T) {
+	var h clipboardHistory
+	const limit = 2
+	h.observed(mimeText, []byte("x"), limit)
+	h.observed(mimeText, []byte("y"), limit)
+	h.observed(mimeText, []byte("z"), limit)
+	if len(h.entries) != limit {
+		t.Fatalf("history has %d entries, want %d", len(h.entries), limit)
+	}
+	// X выпал из истории, поэтому это уже новое содержимое, а не эхо
+	if !h.pushed("a", mimeText, []byte("x"), limit) {
+		t.Error("x beyond history was dropped")
+	}
+	if h.pushed("a", mimeText, []byte("z"), limit) {
+		t.Error("z within history was accepted")
+	}
+}
+
+func TestClipboardHistoryAcceptsOldEntryCopiedAgain(t *testing.T) {
+	var h clipboardHistory
+	const limit = 3
+
+	h.pushed("a", mimeText, []byte("x"), limit)
+	h.observed(mimeText, []byte("x"), limit)
+	h.observed(mimeText, []byte("y"), limit)
+	// Прошло больше clipboardEchoWindow: пользователь снова скопировал X на телефоне
+	for i := range h.entries {
+		h.entries[i].at = h.entries[i].at.Add(-clipboardEchoWindow)
+	}
+	if !h.pushed("a", mimeText, []byte("x"), limit) {
+		t.Error("x copied again on the phone was dropped as an echo")
+	}
+	if !h.pushed("b", mimeText, []byte("y"), limit) {
+		t.Error("y copied again on another phone was dropped as an echo")
+	}
+}
@@ -0,0 +1,150 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/server"
+)
+
+func getRequest(t *testing.T, addr string, req map[string]string) map[string]interface{} {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	defer conn.Close()
+	req["type"] = "get_request"
+	json.NewEncoder(conn).Encode(req)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var resp map[string]interface{}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		t.Error(err)
+	}
+	return resp
+}
+
+// answerGetRequests отвечает на n get_request в обратном порядке, возвращая в sys_info их id.
+func answerGetRequests(p *phone, n int) {
+	var reqs []map[string]interface{}
+	for len(reqs) < n {
+		msg, ok := p.next(5 * time.Second)
+		if !ok {
+			return
+		}
+		if msg["type"] == "get_request" {
+			reqs = append(reqs, msg)
+		}
+	}
+	for i := len(reqs) - 1; i >= 0; i-- {
+		json.NewEncoder(p.conn).Encode(map[string]interface{}{
+			"type": "sys_info", "request_id": reqs[i]["request_id"], "id": reqs[i]["id"],
+		})
+	}
+}
+
+// nonLoopbackIP — адрес этой машины не на lo, чтобы подключиться к себе «по сети».
+func nonLoopbackIP(t *testing.T) string {
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+	}
+	t.Skip("no non-loopback IPv4 address")
+	return ""
+}
+
+func TestGetRequestOnlyFromLoopback(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, nil)
+	port := strconv.Itoa(s.Addr().(*net.TCPAddr).Port)
+	p, _ := connect(t, s, nil)
+
+	go func() {
+		for {
+			req, ok := p.next(2 * time.Second)
+			if !ok {
+				return
+			}
+			if req["type"] == "get_request" {
+				json.NewEncoder(p.conn).Encode(map[string]interface{}{"type": "sys_info", "request_id": req["request_id"], "battery": 80})
+				return
+			}
+		}
+	}()
+	local := getRequest(t, net.JoinHostPort("127.0.0.1", port), map[string]string{"id": "battery"})
+	if local["battery"] != float64(80) {
+		t.Fatalf("local get_request: got %v, want sys_info from the phone", local)
+	}
+
+	remote := getRequest(t, net.JoinHostPort(nonLoopbackIP(t), port), map[string]string{"id": "battery"})
+	if _, ok := remote["devices"]; ok {
+		t.Errorf("device list leaked to a remote caller: %v", remote)
+	}
+	if msg, _ := remote["error"].(string); !strings.Contains(msg, "localhost") {
+		t.Errorf("remote get_request: got %v, want refusal", remote)
+	}
+}
+
+func TestConcurrentGetRequestsGetOwnReplies(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, nil)
+	p, _ := connect(t, s, nil)
+	go answerGetRequests(p, 2)
+
+	ids := []string{"battery", "cpu"}
+	replies := make([]map[string]interface{}, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			replies[i] = getRequest(t, s.Addr().String(), map[string]string{"id": id})
+		}(i, id)
+	}
+	wg.Wait()
+	for i, id := range ids {
+		if replies[i]["id"] != id {
+			t.Errorf("get_request for %s got %v", id, replies[i])
+		}
+	}
+}
+
+func TestGetRequestToChosenDevice(t *testing.T) {
+	s, first, second := twoPhones(t, server.Options{})
+	go answerGetRequests(second, 1)
+
+	resp := getRequest(t, s.Addr().String(), map[string]string{"id": "battery", "device_id": "phone-two"})
+	if resp["id"] != "battery" {
+		t.Fatalf("get_request to phone-two got %v", resp)
+	}
+	for {
+		msg, ok := first.next(200 * time.Millisecond)
+		if !ok {
+			break
+		}
+		if msg["type"] == "get_request" {
+			t.Errorf("get_request for phone-two reached %s", testDevice)
+		}
+	}
+}
+
+func TestGetRequestWithSeveralDevicesListsThem(t *testing.T) {
+	s, _, _ := twoPhones(t, server.Options{})
+	resp := getRequest(t, s.Addr().String(), map[string]string{"id": "battery"})
+	if msg, _ := resp["error"].(string); !strings.Contains(msg, "-device") {
+		t.Errorf("got %v, want an error asking for -device", resp)
+	}
+	devices := fmt.Sprint(resp["devices"])
+	for _, want := range []string{testDevice + " (Test Phone)", "phone-two (Second Phone)"} {
+		if !strings.Contains(devices, want) {
+			t.Errorf("devices %s, want %s listed", devices, want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/Monekx/hyprlink/protocol"
+)
+
+func TestHelloFromNewerClientNegotiatesDown(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, nil)
+	_, resp := connect(t, s, map[string]interface{}{"type": "hello", "version": protocol.Version + 1})
+	if resp["status"] != "ok" && resp["status"] != "update" {
+		t.Fatalf("hello version %d got %v", protocol.Version+1, resp)
+	}
+	if resp["version"] != float64(protocol.Version) {
+		t.Errorf("negotiated version %v, want %d", resp["version"], protocol.Version)
+	}
+}
+
+func TestHelloWithoutCommonVersionRefused(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, nil)
+	_, resp := connect(t, s, map[string]interface{}{
+		"type": "hello", "version": protocol.Version + 2, "min_version": protocol.Version + 1,
+	})
+	if resp["message"] != protocol.ErrUnsupportedVersion {
+		t.Errorf("hello without a common version got %v, want %s", resp, protocol.ErrUnsupportedVersion)
+	}
+}
@@ -0,0 +1,300 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/Monekx/hyprlink/internal/server/servertest"
+	"github.com/Monekx/hyprlink/protocol"
+)
+
+const (
+	testDevice = "phone-test"
+	testToken  = "test-token"
+)
+
+// startServer поднимает сервер на свободном порту с одним доверенным устройством
+// testDevice. Если opts.Runner не задан, используется RecordingRunner.
+func startServer(t *testing.T, opts server.Options, bundle *config.ConfigBundle) (*server.Server, *servertest.RecordingRunner) {
+	t.Helper()
+	dir := t.TempDir()
+	if opts.ConfigDir == "" {
+		opts.ConfigDir = dir
+	}
+	if opts.Devices == nil {
+		opts.Devices = trustedDevices(t, testDevice)
+	}
+	runner, _ := opts.Runner.(*servertest.RecordingRunner)
+	if opts.Runner == nil {
+		runner = &servertest.RecordingRunner{}
+		opts.Runner = runner
+	}
+	s := server.New(opts)
+	if bundle != nil {
+		s.UpdateConfig(bundle)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return s, runner
+}
+
+// trustedDevices — хранилище, где каждому из ids доверено с токеном testToken.
+// testDevice называется Test Phone, phone-two — Second Phone.
+func trustedDevices(t *testing.T, ids ...string) *config.TrustedStore {
+	t.Helper()
+	names := map[string]string{testDevice: "Test Phone", "phone-two": "Second Phone"}
+	store := config.NewTrustedStore(filepath.Join(t.TempDir(), config.TrustedDevicesFile))
+	for _, id := range ids {
+		if err := store.Add(id, names[id], testToken, "127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// phone — тестовый клиент протокола. Сообщения читает отдельная горутина: после
+// таймаута чтения bufio.Scanner больше не работает.
+type phone struct {
+	t        *testing.T
+	conn     net.Conn
+	messages chan map[string]interface{}
+}
+
+func dial(t *testing.T, s *server.Server) *phone {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	p := &phone{t: t, conn: conn, messages: make(chan map[string]interface{}, 256)}
+	go func() {
+		defer close(p.messages)
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var msg map[string]interface{}
+			if json.Unmarshal(scanner.Bytes(), &msg) == nil {
+				p.messages <- msg
+			}
+		}
+	}()
+	return p
+}
+
+// connect подключается как testDevice и возвращает ответ сервера на первое сообщение.
+func connect(t *testing.T, s *server.Server, hello map[string]interface{}) (*phone, map[string]interface{}) {
+	t.Helper()
+	return connectAs(t, s, testDevice, hello)
+}
+
+// connectAs — connect от имени другого доверенного устройства с токеном testToken.
+func connectAs(t *testing.T, s *server.Server, deviceID string, hello map[string]interface{}) (*phone, map[string]interface{}) {
+	t.Helper()
+	p := dial(t, s)
+	if hello == nil {
+		hello = map[string]interface{}{}
+	}
+	hello["device_id"], hello["token"] = deviceID, testToken
+	p.send(hello)
+	resp, ok := p.next(2 * time.Second)
+	if !ok {
+		t.Fatal("no response to the first message")
+	}
+	return p, resp
+}
+
+// hello — первое сообщение клиента текущей версии протокола с возможностями caps.
+func hello(caps ...string) map[string]interface{} {
+	return map[string]interface{}{"type": "hello", "version": protocol.Version, "capabilities": caps}
+}
+
+// resumeHello — hello, который продолжает сессию session.
+func resumeHello(session interface{}, caps ...string) map[string]interface{} {
+	msg := hello(caps...)
+	msg["session"] = session
+	return msg
+}
+
+// twoPhones запускает сервер с двумя доверенными устройствами, testDevice и phone-two,
+// и подключает оба с возможностями caps.
+func twoPhones(t *testing.T, opts server.Options, caps ...string) (s *server.Server, first, second *phone) {
+	t.Helper()
+	opts.Devices = trustedDevices(t, testDevice, "phone-two")
+	s, _ = startServer(t, opts, nil)
+	first, _ = connect(t, s, hello(caps...))
+	second, _ = connectAs(t, s, "phone-two", hello(caps...))
+	return s, first, second
+}
+
+// dialSilent подключается как testDevice и больше ничего не читает: так ведёт себя
+// зависший телефон, у которого заполняется буфер сокета.
+func dialSilent(t *testing.T, s *server.Server, hello map[string]interface{}) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if hello == nil {
+		hello = map[string]interface{}{}
+	}
+	hello["device_id"], hello["token"] = testDevice, testToken
+	if err := json.NewEncoder(conn).Encode(hello); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// clogClient рассылает раскладку, которая не помещается в буферы сокета: пока клиент
+// не читает, горутина записи стоит на ней, а остальные сообщения ждут в очереди.
+func clogClient(s *server.Server) {
+	s.BroadcastUpdate(&config.UIConfig{Hash: "clog", CSS: strings.Repeat("x", 32<<20)})
+	time.Sleep(200 * time.Millisecond)
+}
+
+func (p *phone) send(msg map[string]interface{}) {
+	p.t.Helper()
+	if err := json.NewEncoder(p.conn).Encode(msg); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// next ждёт следующее сообщение; ok=false при таймауте или закрытом соединении.
+func (p *phone) next(timeout time.Duration) (map[string]interface{}, bool) {
+	select {
+	case msg, ok := <-p.messages:
+		return msg, ok
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+// closed ждёт, пока сервер закроет соединение.
+func (p *phone) closed(timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case _, ok := <-p.messages:
+			if !ok {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// expect пропускает сообщения других типов, пока не придёт msgType.
+func (p *phone) expect(msgType string, timeout time.Duration) map[string]interface{} {
+	p.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		msg, ok := p.next(time.Until(deadline))
+		if !ok {
+			p.t.Fatalf("no %s message within %s", msgType, timeout)
+		}
+		if msg["type"] == msgType {
+			return msg
+		}
+	}
+}
+
+// streamBundle — модули ids со stream-источником, каждый с командой "stream-<id>".
+func streamBundle(ids ...string) *config.ConfigBundle {
+	bundle := &config.ConfigBundle{Settings: config.DefaultSettings()}
+	var modules []config.Module
+	for _, id := range ids {
+		modules = append(modules, config.Module{ID: id, Type: "display", Source: "stream-" + id, SourceMode: config.SourceStream})
+	}
+	bundle.UI.Profiles = []config.Tab{{Name: "Streams", Modules: modules}}
+	return bundle
+}
+
+// gatedStreams — StreamHandler, который выводит lines потоков streamBundle после закрытия
+// release и сообщает об этом в done. Остальные потоки ничего не выводят.
+func gatedStreams(release <-chan struct{}, done chan<- struct{}, lines ...string) func(context.Context, server.Cmd, func(string)) error {
+	return func(ctx context.Context, cmd server.Cmd, onLine func(string)) error {
+		if !strings.HasPrefix(cmd.String(), server.Shell("stream-").String()) {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		// Оба случая select могут быть готовы сразу: остановленный поток ничего не выводит
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		for _, l := range lines {
+			onLine(l)
+		}
+		done <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+}
+
+// readUntilClosed читает соединение до конца; false, если оно не закрылось за timeout.
+func readUntilClosed(conn net.Conn, timeout time.Duration) bool {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err := io.Copy(io.Discard, conn)
+	var netErr net.Error
+	return !(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// pngData — пустая картинка w×h в PNG.
+func pngData(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// startBus запускает отдельный dbus-daemon и возвращает его адрес.
+func startBus(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address=1")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(addr)
+}
@@ -0,0 +1,81 @@
+package server_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/hyprland/hyprlandtest"
+	"github.com/Monekx/hyprlink/internal/server"
+)
+
+// Записанный вывод socat от .socket2.sock.
+const recordedEvents = `workspace>>3
+activewindow>>kitty,~/src/hyprlink
+focusedmon>>DP-1,3
+workspace>>5
+`
+
+func TestHyprlandEventsBecomeUpdates(t *testing.T) {
+	fake, err := hyprlandtest.Start(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+
+	bundle := &config.ConfigBundle{Settings: config.DefaultSettings()}
+	bundle.UI.Profiles = []config.Tab{{Name: "Desktop", Modules: []config.Module{
+		{ID: "ws", Type: "display", Source: "workspace", SourceMode: config.SourceHyprland},
+		{ID: "window", Type: "display", Source: "activewindow", SourceMode: config.SourceHyprland},
+	}}}
+	bundle.Actions = map[string]config.Action{"goto": {Dispatch: "workspace {v}"}}
+	s, _ := startServer(t, server.Options{Hyprland: fake.Client()}, bundle)
+	p, _ := connect(t, s, nil)
+
+	for deadline := time.Now().Add(2 * time.Second); fake.Subscribers() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not subscribe to hyprland events")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := fake.Replay(strings.NewReader(recordedEvents), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]interface{})
+	for deadline := time.Now().Add(2 * time.Second); got["ws"] != float64(5) || got["window"] == nil; {
+		msg, ok := p.next(time.Until(deadline))
+		if !ok {
+			t.Fatalf("updates so far %v, want ws=5 and window", got)
+		}
+		if msg["type"] != "update" {
+			continue
+		}
+		if v, ok := msg["value"]; ok {
+			got[msg["id"].(string)] = v
+		} else {
+			got[msg["id"].(string)] = msg["content"]
+		}
+	}
+	if got["window"] != "kitty,~/src/hyprlink" {
+		t.Errorf("window = %v", got["window"])
+	}
+
+	p.send(map[string]interface{}{"type": "action", "id": "goto", "value": 2})
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		found := false
+		for _, r := range fake.Requests() {
+			if r == "dispatch workspace 2" {
+				found = true
+			}
+		}
+		if found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("requests %q, want dispatch workspace 2", fake.Requests())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
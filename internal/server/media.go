@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/media"
+	"github.com/Monekx/hyprlink/protocol"
+)
+
+const (
+	// mediaActionTimeout ограничивает вызовы методов плеера: зависший плеер не должен держать горутину.
+	mediaActionTimeout = 5 * time.Second
+	// artCacheSize — сколько уменьшенных обложек держать в памяти.
+	artCacheSize = 32
+)
+
+// watchMedia следит за MPRIS-плеерами и рассылает media_info только при изменениях.
+// Телефон сам интерполирует позицию между сообщениями по status и rate.
+func (s *Server) watchMedia() {
+	bus, err := s.sessionBus()
+	if err != nil {
+		fmt.Printf("Media: session bus unavailable: %v\n", err)
+		return
+	}
+	w := media.NewWatcher(bus, s.broadcastMediaStatus)
+	s.mediaMu.Lock()
+	s.media = w
+	s.mediaMu.Unlock()
+
+	if err := w.Run(s.ctx); err != nil && s.ctx.Err() == nil {
+		fmt.Printf("Media: %v\n", err)
+	}
+}
+
+// MediaPlayer — один плеер в media_info.players.
+type MediaPlayer = protocol.MediaPlayer
+
+// mediaInfo собирает media_info: поля верхнего уровня описывают плеер, которым управляет
+// устройство, players — все плееры. false, если MPRIS недоступен.
+func (s *Server) mediaInfo(preferred string) (Response, bool) {
+	s.mediaMu.Lock()
+	w := s.media
+	s.mediaMu.Unlock()
+	if w == nil {
+		return Response{}, false
+	}
+
+	resp := Response{Type: protocol.TypeMediaInfo, Content: "Ничего не воспроизводится"}
+	for _, p := range w.Players() {
+		resp.Players = append(resp.Players, MediaPlayer{
+			Name:     p.Name,
+			Identity: p.Identity,
+			Status:   p.Status,
+			Title:    p.Title,
+			Artist:   p.Artist,
+			Album:    p.Album,
+			ArtURL:   p.ArtURL,
+			Position: p.PositionNow().Milliseconds(),
+			Duration: p.Length.Milliseconds(),
+			Shuffle:  p.Shuffle,
+			Loop:     p.Loop,
+			Rate:     p.Rate,
+		})
+	}
+	p, ok := w.Active(preferred)
+	if !ok {
+		return resp, true
+	}
+	resp.Player = p.Name
+	resp.Status = p.Status
+	resp.Shuffle = p.Shuffle
+	resp.Loop = p.Loop
+	resp.Rate = p.Rate
+	if p.Title != "" {
+		resp.Content = p.Title
+		resp.App = p.Artist
+		resp.Album = p.Album
+		resp.ArtURL = p.ArtURL
+		resp.Value = float64(p.PositionNow().Milliseconds())
+		resp.Duration = p.Length.Milliseconds()
+	}
+	return resp, true
+}
+
+func (s *Server) broadcastMediaStatus() {
+	if _, ok := s.mediaInfo(""); !ok {
+		return
+	}
+	infos := make(map[string]Response)
+	s.broadcastKeyed(mediaInfoKey, func(c *client) (Response, bool) {
+		info, ok := infos[c.mediaPlayer]
+		if !ok {
+			info, _ = s.mediaInfo(c.mediaPlayer)
+			infos[c.mediaPlayer] = info
+		}
+		return info, true
+	})
+	s.sendMediaArt()
+}
+
+// setArtSize включает отправку обложек клиенту. Смена размера заново отправляет все обложки.
+func (s *Server) setArtSize(c *client, size int) {
+	if size > 0 {
+		size = min(max(size, media.MinArtSize), media.MaxArtSize)
+	}
+	s.mu.Lock()
+	c.artSize = size
+	c.sentArt = make(map[string]bool)
+	s.mu.Unlock()
+	go s.sendMediaArt()
+}
+
+// sendMediaArt отправляет в media_art file://-обложки плееров, которых у клиента ещё нет.
+func (s *Server) sendMediaArt() {
+	s.mediaMu.Lock()
+	w := s.media
+	s.mediaMu.Unlock()
+	if w == nil {
+		return
+	}
+	var urls []string
+	for _, p := range w.Players() {
+		if strings.HasPrefix(p.ArtURL, "file://") {
+			urls = append(urls, p.ArtURL)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	type artKey struct {
+		url  string
+		size int
+	}
+	needed := make(map[artKey]string)
+	s.mu.Lock()
+	for _, c := range s.clients {
+		for _, u := range urls {
+			if c.artSize > 0 && !c.sentArt[u] {
+				needed[artKey{u, c.artSize}] = ""
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	// Загрузка и масштабирование идут без s.mu, чтобы не задерживать остальные рассылки
+	for key := range needed {
+		data, err := s.art.Get(key.url, key.size)
+		if err != nil {
+			fmt.Printf("Media art %s: %v\n", key.url, err)
+			continue
+		}
+		needed[key] = base64.StdEncoding.EncodeToString(data)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		for _, u := range urls {
+			data, ok := needed[artKey{u, c.artSize}]
+			if !ok || c.sentArt[u] {
+				continue
+			}
+			// Неудачная загрузка не отмечается: обложку попробуем снова на следующем сигнале,
+			// а ArtCache не даст перечитывать битый файл чаще раза в artRetry
+			if data != "" && c.send("", Response{Type: protocol.TypeMediaArt, ArtURL: u, Mime: "image/jpeg", Content: data}) {
+				c.sentArt[u] = true
+			}
+		}
+	}
+}
+
+// handleMediaAction управляет плеером из поля player или последним выбранным на устройстве
+// и запоминает выбор. Ошибку получает только устройство c.
+func (s *Server) handleMediaAction(c *client, actionID string, value float64, player string) {
+	s.mediaMu.Lock()
+	w := s.media
+	s.mediaMu.Unlock()
+	if w == nil {
+		return
+	}
+
+	if player != "" {
+		s.rememberPlayer(c.deviceID, player)
+	} else {
+		player = s.devicePlayer(c.deviceID)
+	}
+	if actionID == protocol.ActionMediaSelect {
+		s.broadcastMediaStatus()
+		return
+	}
+
+	p, ok := w.Active(player)
+	if !ok {
+		return
+	}
+	if player != "" && p.Name != player {
+		// Выбранный плеер закрыт: управляем автоматически выбранным, но запомненный выбор не трогаем
+		fmt.Printf("Media player %s is gone, using %s\n", player, p.Name)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, mediaActionTimeout)
+	defer cancel()
+
+	var err error
+	switch actionID {
+	case protocol.ActionMediaPlay:
+		err = w.Call(ctx, p.Name, "Play")
+	case protocol.ActionMediaPause:
+		err = w.Call(ctx, p.Name, "Pause")
+	case protocol.ActionMediaToggle:
+		err = w.Call(ctx, p.Name, "PlayPause")
+	case protocol.ActionMediaNext:
+		err = w.Call(ctx, p.Name, "Next")
+	case protocol.ActionMediaPrev:
+		err = w.Call(ctx, p.Name, "Previous")
+	case protocol.ActionMediaSeek:
+		// value — позиция в секундах, как у playerctl position
+		err = w.SetPosition(ctx, p.Name, time.Duration(value*float64(time.Second)))
+	case protocol.ActionMediaShuffle:
+		err = w.SetShuffle(ctx, p.Name, value != 0)
+	case protocol.ActionMediaLoop:
+		loops := []string{"none", "track", "playlist"}
+		if i := int(value); i >= 0 && i < len(loops) {
+			err = w.SetLoop(ctx, p.Name, loops[i])
+		}
+	default:
+		fmt.Printf("Unknown media action %s\n", actionID)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Media action %s on %s failed: %v\n", actionID, p.Name, err)
+		c.send("", Response{Type: protocol.TypeError, ID: actionID, Message: err.Error()})
+	}
+}
+
+// devicePlayer — плеер, выбранный на устройстве, или пустая строка.
+func (s *Server) devicePlayer(deviceID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		if c.deviceID == deviceID {
+			return c.mediaPlayer
+		}
+	}
+	return ""
+}
+
+// rememberPlayer запоминает выбор для всех подключений устройства и в trusted_devices.json,
+// чтобы он пережил переподключение.
+func (s *Server) rememberPlayer(deviceID, player string) {
+	changed := false
+	s.mu.Lock()
+	for _, c := range s.clients {
+		if c.deviceID == deviceID && c.mediaPlayer != player {
+			c.mediaPlayer = player
+			changed = true
+		}
+	}
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	if err := s.devices.SetMediaPlayer(deviceID, player); err != nil {
+		fmt.Printf("Error saving media player for %s: %v\n", deviceID, err)
+	}
+}
+
+// isMediaAction — встроенное ли это действие media_*. Остальные id, в том числе
+// пользовательские media_volume и подобные, идут в таблицу действий.
+func isMediaAction(actionID string) bool {
+	return protocol.MediaActions[actionID]
+}
@@ -0,0 +1,277 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const (
+	mprisPath   = dbus.ObjectPath("/org/mpris/MediaPlayer2")
+	mprisRoot   = "org.mpris.MediaPlayer2"
+	mprisPlayer = "org.mpris.MediaPlayer2.Player"
+)
+
+// fakePlayer — MPRIS-плеер на тестовой шине; вызовы методов уходят в calls.
+type fakePlayer struct {
+	calls chan string
+}
+
+func (f *fakePlayer) PlayPause() *dbus.Error { f.calls <- "PlayPause"; return nil }
+func (f *fakePlayer) Play() *dbus.Error      { f.calls <- "Play"; return nil }
+func (f *fakePlayer) Pause() *dbus.Error     { f.calls <- "Pause"; return nil }
+func (f *fakePlayer) Next() *dbus.Error      { f.calls <- "Next"; return nil }
+func (f *fakePlayer) Previous() *dbus.Error  { f.calls <- "Previous"; return nil }
+
+func startPlayer(t *testing.T, addr, name string) (*fakePlayer, *prop.Properties) {
+	t.Helper()
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	player := &fakePlayer{calls: make(chan string, 8)}
+	if err := conn.Export(player, mprisPath, mprisPlayer); err != nil {
+		t.Fatal(err)
+	}
+	props, err := prop.Export(conn, mprisPath, prop.Map{
+		mprisRoot: {
+			"Identity": {Value: "Fake Player", Emit: prop.EmitTrue},
+		},
+		mprisPlayer: {
+			"PlaybackStatus": {Value: "Playing", Emit: prop.EmitTrue},
+			"Metadata": {Value: map[string]dbus.Variant{
+				"mpris:trackid": dbus.MakeVariant(dbus.ObjectPath("/track/1")),
+				"xesam:title":   dbus.MakeVariant("First Song"),
+				"xesam:artist":  dbus.MakeVariant([]string{"Someone"}),
+				"mpris:length":  dbus.MakeVariant(int64(180_000_000)),
+			}, Emit: prop.EmitTrue},
+			"Position":   {Value: int64(0), Emit: prop.EmitFalse},
+			"Rate":       {Value: 1.0, Emit: prop.EmitTrue},
+			"Shuffle":    {Value: false, Emit: prop.EmitTrue},
+			"LoopStatus": {Value: "None", Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.RequestName(mprisRoot+"."+name, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request name: %v %v", reply, err)
+	}
+	return player, props
+}
+
+// waitMedia ждёт media_info, для которого match вернёт true.
+func waitMedia(t *testing.T, p *phone, match func(map[string]interface{}) bool) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		msg := p.expect("media_info", time.Until(deadline))
+		if match(msg) {
+			return msg
+		}
+	}
+}
+
+func TestMediaFromMPRIS(t *testing.T) {
+	addr := startBus(t)
+	player, props := startPlayer(t, addr, "fake")
+	s, _ := startServer(t, server.Options{SessionBusAddress: addr}, nil)
+	p, _ := connect(t, s, hello("media"))
+
+	// До чтения свойств плеер не публикуется, но ждём именно трек, а не первое упоминание плеера
+	info := waitMedia(t, p, func(m map[string]interface{}) bool {
+		return m["player"] == "fake" && m["content"] == "First Song" && m["status"] == "playing"
+	})
+	if info["content"] != "First Song" || info["app"] != "Someone" || info["status"] != "playing" {
+		t.Errorf("media_info %v, want First Song by Someone, playing", info)
+	}
+	if info["duration"] != float64(180_000) {
+		t.Errorf("duration %v, want 180000 ms", info["duration"])
+	}
+
+	// Изменение свойства приходит сигналом PropertiesChanged, без опроса
+	props.SetMust(mprisPlayer, "PlaybackStatus", "Paused")
+	waitMedia(t, p, func(m map[string]interface{}) bool { return m["status"] == "paused" })
+
+	p.send(map[string]interface{}{"type": "action", "id": "media_toggle"})
+	select {
+	case call := <-player.calls:
+		if call != "PlayPause" {
+			t.Errorf("player got %s, want PlayPause", call)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("media_toggle did not reach the player")
+	}
+}
+
+func TestMediaActionErrorOnlyToSender(t *testing.T) {
+	addr := startBus(t)
+	startPlayer(t, addr, "fake")
+	_, first, second := twoPhones(t, server.Options{SessionBusAddress: addr}, "media")
+	waitMedia(t, first, func(m map[string]interface{}) bool { return m["player"] == "fake" })
+
+	// Shuffle у плеера только для чтения, поэтому действие завершается ошибкой
+	first.send(map[string]interface{}{"type": "action", "id": "media_shuffle", "value": 1})
+	if msg := first.expect("error", 3*time.Second); msg["id"] != "media_shuffle" {
+		t.Errorf("error %v, want one for media_shuffle", msg)
+	}
+	for {
+		msg, ok := second.next(300 * time.Millisecond)
+		if !ok {
+			break
+		}
+		if msg["type"] == "error" {
+			t.Errorf("media error for %s reached phone-two: %v", testDevice, msg)
+		}
+	}
+}
+
+// expectCall ждёт вызов method у плеера и проверяет, что other ничего не получил.
+func expectCall(t *testing.T, player, other *fakePlayer, method string) {
+	t.Helper()
+	select {
+	case call := <-player.calls:
+		if call != method {
+			t.Errorf("player got %s, want %s", call, method)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s did not reach the player", method)
+	}
+	select {
+	case call := <-other.calls:
+		t.Errorf("other player got %s", call)
+	default:
+	}
+}
+
+// twoPlayers запускает играющий spotify и поставленный на паузу firefox. Без выбора
+// устройством сервер управляет spotify.
+func twoPlayers(t *testing.T, addr string) (spotify, firefox *fakePlayer) {
+	t.Helper()
+	spotify, _ = startPlayer(t, addr, "spotify")
+	firefox, props := startPlayer(t, addr, "firefox")
+	props.SetMust(mprisPlayer, "PlaybackStatus", "Paused")
+	return spotify, firefox
+}
+
+func TestMediaInfoListsAllPlayers(t *testing.T) {
+	addr := startBus(t)
+	twoPlayers(t, addr)
+	s, _ := startServer(t, server.Options{SessionBusAddress: addr}, nil)
+	p, _ := connect(t, s, hello("media"))
+
+	info := waitMedia(t, p, func(m map[string]interface{}) bool {
+		players, _ := m["players"].([]interface{})
+		return len(players) == 2 && players[0].(map[string]interface{})["status"] == "paused"
+	})
+	players := info["players"].([]interface{})
+	for i, want := range []struct{ name, status string }{{"firefox", "paused"}, {"spotify", "playing"}} {
+		got := players[i].(map[string]interface{})
+		if got["name"] != want.name || got["status"] != want.status || got["identity"] != "Fake Player" || got["title"] != "First Song" {
+			t.Errorf("players[%d] = %v, want %s %s", i, got, want.name, want.status)
+		}
+	}
+	if info["player"] != "spotify" {
+		t.Errorf("controlled player %v, want the playing spotify", info["player"])
+	}
+}
+
+func TestMediaActionTargetsChosenPlayer(t *testing.T) {
+	addr := startBus(t)
+	spotify, firefox := twoPlayers(t, addr)
+	devices := trustedDevices(t, testDevice)
+	s, _ := startServer(t, server.Options{SessionBusAddress: addr, Devices: devices}, nil)
+	p, _ := connect(t, s, hello("media"))
+	waitMedia(t, p, func(m map[string]interface{}) bool {
+		players, _ := m["players"].([]interface{})
+		return len(players) == 2
+	})
+
+	p.send(map[string]interface{}{"type": "action", "id": "media_next", "player": "firefox"})
+	expectCall(t, firefox, spotify, "Next")
+	// Без player действие идёт к последнему выбранному плееру, а не к играющему
+	p.send(map[string]interface{}{"type": "action", "id": "media_toggle"})
+	expectCall(t, firefox, spotify, "PlayPause")
+	if dev, _ := devices.Get(testDevice); dev.MediaPlayer != "firefox" {
+		t.Errorf("stored media player %q, want firefox", dev.MediaPlayer)
+	}
+
+	// Выбор переживает переподключение
+	p.conn.Close()
+	p, _ = connect(t, s, hello("media"))
+	info := waitMedia(t, p, func(m map[string]interface{}) bool { return m["player"] != nil })
+	if info["player"] != "firefox" {
+		t.Errorf("after reconnect the device controls %v, want firefox", info["player"])
+	}
+	p.send(map[string]interface{}{"type": "action", "id": "media_prev"})
+	expectCall(t, firefox, spotify, "Previous")
+}
+
+// expectArt ждёт media_art и возвращает размеры присланной JPEG-обложки.
+func expectArt(t *testing.T, p *phone, artURL string) image.Config {
+	t.Helper()
+	msg := p.expect("media_art", 3*time.Second)
+	if msg["art_url"] != artURL || msg["mime"] != "image/jpeg" {
+		t.Fatalf("media_art %v, want image/jpeg for %s", msg["art_url"], artURL)
+	}
+	data, err := base64.StdEncoding.DecodeString(msg["content"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "jpeg" {
+		t.Fatalf("media_art content is %s: %v", format, err)
+	}
+	return cfg
+}
+
+func TestMediaArtSentOncePerSize(t *testing.T) {
+	cover := filepath.Join(t.TempDir(), "cover.png")
+	if err := os.WriteFile(cover, pngData(t, 300, 300), 0644); err != nil {
+		t.Fatal(err)
+	}
+	artURL := "file://" + cover
+
+	addr := startBus(t)
+	_, props := startPlayer(t, addr, "fake")
+	props.SetMust(mprisPlayer, "Metadata", map[string]dbus.Variant{
+		"mpris:trackid": dbus.MakeVariant(dbus.ObjectPath("/track/1")),
+		"xesam:title":   dbus.MakeVariant("First Song"),
+		"mpris:artUrl":  dbus.MakeVariant(artURL),
+	})
+	s, _ := startServer(t, server.Options{SessionBusAddress: addr}, nil)
+	p, _ := connect(t, s, hello("media"))
+	waitMedia(t, p, func(m map[string]interface{}) bool { return m["art_url"] == artURL })
+
+	p.send(map[string]interface{}{"type": "media_art_size", "value": 64})
+	if cfg := expectArt(t, p, artURL); cfg.Width != 64 {
+		t.Errorf("art is %dx%d, want 64 wide", cfg.Width, cfg.Height)
+	}
+
+	// Новое media_info той же обложки не повторяет картинку
+	props.SetMust(mprisPlayer, "PlaybackStatus", "Paused")
+	for {
+		msg, ok := p.next(500 * time.Millisecond)
+		if !ok {
+			break
+		}
+		if msg["type"] == "media_art" {
+			t.Fatal("media_art sent again for the same url and size")
+		}
+	}
+
+	p.send(map[string]interface{}{"type": "media_art_size", "value": 128})
+	if cfg := expectArt(t, p, artURL); cfg.Width != 128 {
+		t.Errorf("art after resize is %dx%d, want 128 wide", cfg.Width, cfg.Height)
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/notify"
+	"github.com/Monekx/hyprlink/protocol"
+)
+
+const (
+	notifyTimeout = 5 * time.Second
+	// notifyAppName — app_name собственных уведомлений сервера.
+	notifyAppName = "HyprLink"
+)
+
+// phoneNotification — уведомление телефона, показанное на компьютере.
+// key — id уведомления на телефоне, по нему телефон узнаёт, к чему относится нажатие кнопки.
+type phoneNotification struct {
+	deviceID string
+	key      string
+}
+
+// watchNotifications подключается к демону уведомлений и ловит нажатия кнопок.
+func (s *Server) watchNotifications() {
+	bus, err := s.sessionBus()
+	if err != nil {
+		fmt.Printf("Notifications: session bus unavailable: %v\n", err)
+		return
+	}
+	n := notify.NewClient(bus)
+	n.OnAction = s.notificationAction
+	n.OnClosed = s.notificationClosed
+	s.notifyMu.Lock()
+	s.notifier = n
+	s.notifyMu.Unlock()
+
+	if err := n.Run(s.ctx); err != nil && s.ctx.Err() == nil {
+		fmt.Printf("Notifications: %v\n", err)
+	}
+}
+
+// handleNotification показывает уведомление с телефона. Повтор с тем же id обновляет
+// показанное уведомление, а не добавляет новое.
+func (s *Server) handleNotification(c *client, data map[string]interface{}) {
+	app, _ := data["app"].(string)
+	title, _ := data["title"].(string)
+	content, _ := data["content"].(string)
+	key, _ := data["id"].(string)
+
+	if s.currentSettings().Notifications.Muted(app, title) {
+		return
+	}
+	s.notifyMu.Lock()
+	notifier := s.notifier
+	s.notifyMu.Unlock()
+	if notifier == nil {
+		fmt.Printf("Notification from %s dropped: no notification daemon\n", c.deviceID)
+		return
+	}
+
+	n := notify.Notification{
+		AppName: app,
+		Summary: title,
+		Body:    content,
+		Urgency: parseUrgency(data["urgency"]),
+		Timeout: -1,
+	}
+	if icon, _ := data["icon"].(string); icon != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(icon); err == nil {
+			n.Icon = decoded
+		}
+	}
+	// Без id телефону нельзя сообщить, какое уведомление нажали, поэтому кнопки не показываем
+	if key != "" {
+		actions, _ := data["actions"].([]interface{})
+		for _, raw := range actions {
+			a, _ := raw.(map[string]interface{})
+			id, _ := a["id"].(string)
+			label, _ := a["label"].(string)
+			if id != "" {
+				n.Actions = append(n.Actions, notify.Action{Key: id, Label: label})
+			}
+		}
+	}
+
+	s.showMu.Lock()
+	defer s.showMu.Unlock()
+	shownKey := phoneNotification{deviceID: c.deviceID, key: key}
+	if key != "" {
+		s.notifyMu.Lock()
+		n.ReplacesID = s.notifyShown[shownKey]
+		s.notifyMu.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, notifyTimeout)
+	defer cancel()
+	id, err := notifier.Notify(ctx, n)
+	if err != nil {
+		// Битая иконка не повод терять уведомление
+		if n.Icon != nil {
+			n.Icon = nil
+			id, err = notifier.Notify(ctx, n)
+		}
+		if err != nil {
+			fmt.Printf("Notification from %s failed: %v\n", c.deviceID, err)
+			return
+		}
+	}
+	if key != "" {
+		s.notifyMu.Lock()
+		if old := s.notifyShown[shownKey]; old != 0 && old != id {
+			delete(s.notifyByID, old)
+		}
+		s.notifyShown[shownKey] = id
+		s.notifyByID[id] = shownKey
+		s.notifyMu.Unlock()
+	}
+}
+
+// notificationAction отправляет нажатие кнопки устройству, с которого пришло уведомление.
+func (s *Server) notificationAction(id uint32, action string) {
+	s.notifyMu.Lock()
+	shown, ok := s.notifyByID[id]
+	s.notifyMu.Unlock()
+	if !ok {
+		return
+	}
+	resp := Response{Type: protocol.TypeNotificationAction, ID: shown.key, Action: action}
+	s.broadcastEach(func(c *client) (Response, bool) {
+		return resp, c.deviceID == shown.deviceID
+	})
+}
+
+func (s *Server) notificationClosed(id uint32) {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+	if shown, ok := s.notifyByID[id]; ok {
+		delete(s.notifyByID, id)
+		delete(s.notifyShown, shown)
+	}
+}
+
+// watchDesktopNotifications пересылает на телефоны уведомления приложений компьютера.
+// Монитору шины нужно отдельное соединение: после BecomeMonitor через него ничего не отправить.
+func (s *Server) watchDesktopNotifications() {
+	// Свои уведомления (пришедшие с телефона) отсеиваем по имени основного соединения
+	var own string
+	if bus, err := s.sessionBus(); err == nil && len(bus.Names()) > 0 {
+		own = bus.Names()[0]
+	}
+	conn, err := s.connectSessionBus()
+	if err != nil {
+		fmt.Printf("Desktop notifications: session bus unavailable: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	err = notify.Monitor(s.ctx, conn, func(n notify.Observed) {
+		if n.Sender != "" && n.Sender == own {
+			return
+		}
+		s.forwardNotification(n)
+	})
+	if err != nil && s.ctx.Err() == nil {
+		fmt.Printf("Desktop notifications: %v\n", err)
+	}
+}
+
+func (s *Server) forwardNotification(n notify.Observed) {
+	// PIN сопряжения и ошибки PIN показывает отдельный notify-send, а не наше
+	// соединение; на телефоны они попасть не должны ни при каком forward
+	if strings.EqualFold(n.AppName, notifyAppName) {
+		return
+	}
+	settings := s.currentSettings().Notifications
+	if !settings.Forwarded(n.AppName, n.Summary) {
+		return
+	}
+	resp := Response{
+		Type:    protocol.TypeDesktopNotification,
+		App:     n.AppName,
+		Title:   n.Summary,
+		Content: n.Body,
+		Value:   float64(n.Urgency),
+	}
+	if settings.HideWhenLocked && s.screenLocked() {
+		resp.Title, resp.Content, resp.Hidden = "", "", true
+	}
+	s.broadcastEach(func(*client) (Response, bool) {
+		return resp, true
+	})
+}
+
+// screenLocked спрашивает у logind, заблокирована ли текущая сессия.
+func (s *Server) screenLocked() bool {
+	session := os.Getenv("XDG_SESSION_ID")
+	if session == "" {
+		session = "auto"
+	}
+	out, err := s.output(Command("loginctl", "show-session", session, "-p", "LockedHint", "--value"))
+	if err != nil {
+		// Не узнали — считаем заблокированным, чтобы не показать лишнего
+		return true
+	}
+	return strings.TrimSpace(string(out)) == "yes"
+}
+
+// parseUrgency принимает low/normal/critical или число 0-2.
+func parseUrgency(v interface{}) byte {
+	switch u := v.(type) {
+	case string:
+		switch u {
+		case "low":
+			return notify.UrgencyLow
+		case "critical":
+			return notify.UrgencyCritical
+		}
+	case float64:
+		if u >= 0 && u <= 2 {
+			return byte(u)
+		}
+	}
+	return notify.UrgencyNormal
+}
@@ -0,0 +1,243 @@
+package server_test
+
+import (
+	"encoding/base64"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/godbus/dbus/v5"
+)
+
+const notificationsPath = dbus.ObjectPath("/org/freedesktop/Notifications")
+
+// notifyCall — аргументы одного вызова Notify и id, который вернул демон.
+type notifyCall struct {
+	id       uint32
+	app      string
+	replaces uint32
+	summary  string
+	actions  []string
+	hints    map[string]dbus.Variant
+}
+
+// fakeDaemon — демон уведомлений на тестовой шине; вызовы Notify уходят в calls.
+type fakeDaemon struct {
+	conn  *dbus.Conn
+	calls chan notifyCall
+
+	mu     sync.Mutex
+	lastID uint32
+}
+
+func (d *fakeDaemon) Notify(app string, replaces uint32, icon, summary, body string,
+	actions []string, hints map[string]dbus.Variant, timeout int32) (uint32, *dbus.Error) {
+	d.mu.Lock()
+	id := replaces
+	if id == 0 {
+		d.lastID++
+		id = d.lastID
+	}
+	d.mu.Unlock()
+	d.calls <- notifyCall{app: app, replaces: replaces, summary: summary, actions: actions, hints: hints, id: id}
+	return id, nil
+}
+
+func startDaemon(t *testing.T, addr string) *fakeDaemon {
+	t.Helper()
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	d := &fakeDaemon{conn: conn, calls: make(chan notifyCall, 16)}
+	if err := conn.Export(d, notificationsPath, "org.freedesktop.Notifications"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.RequestName("org.freedesktop.Notifications", dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request name: %v %v", reply, err)
+	}
+	return d
+}
+
+// waitNotifier ждёт, пока сервер подключится к демону: до этого уведомления с телефона
+// отбрасываются. Пробные уведомления без id ничего не заменяют.
+func waitNotifier(t *testing.T, p *phone, d *fakeDaemon) {
+	t.Helper()
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); {
+		p.send(map[string]interface{}{"type": "notification", "app": "test", "title": "ready"})
+		select {
+		case <-d.calls:
+			// Повторы, которые успели уйти
+			for {
+				select {
+				case <-d.calls:
+				case <-time.After(300 * time.Millisecond):
+					return
+				}
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatal("server did not connect to the notification daemon")
+}
+
+// nextCall ждёт следующий вызов Notify.
+func nextCall(t *testing.T, d *fakeDaemon) notifyCall {
+	t.Helper()
+	select {
+	case call := <-d.calls:
+		return call
+	case <-time.After(2 * time.Second):
+		t.Fatal("notification did not reach the daemon")
+		return notifyCall{}
+	}
+}
+
+func sendNotify(t *testing.T, conn *dbus.Conn, app, summary string) {
+	t.Helper()
+	call := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications").Go(
+		"org.freedesktop.Notifications.Notify", dbus.FlagNoReplyExpected, nil,
+		app, uint32(0), "", summary, "body", []string{}, map[string]dbus.Variant{}, int32(-1))
+	if call.Err != nil {
+		t.Fatal(call.Err)
+	}
+}
+
+func TestDesktopNotificationsForwarded(t *testing.T) {
+	addr := startBus(t)
+	bundle := &config.ConfigBundle{Settings: config.DefaultSettings()}
+	bundle.Settings.Notifications.Forward = []config.NotificationRule{{App: "*"}}
+	s, _ := startServer(t, server.Options{SessionBusAddress: addr}, bundle)
+	p, _ := connect(t, s, hello("notifications"))
+
+	app, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+
+	// Монитор поднимается асинхронно: шлём, пока первое уведомление не дойдёт
+	var first map[string]interface{}
+	for deadline := time.Now().Add(3 * time.Second); first == nil && time.Now().Before(deadline); {
+		sendNotify(t, app, "firefox", "Download finished")
+		for {
+			msg, ok := p.next(200 * time.Millisecond)
+			if !ok {
+				break
+			}
+			if msg["type"] == "desktop_notification" {
+				first = msg
+				break
+			}
+		}
+	}
+	if first == nil || first["app"] != "firefox" || first["title"] != "Download finished" {
+		t.Fatalf("got %v, want the firefox notification", first)
+	}
+	for {
+		if _, ok := p.next(300 * time.Millisecond); !ok {
+			break
+		}
+	}
+
+	// PIN сопряжения от notify-send не должен уйти на телефон даже при forward: "*"
+	sendNotify(t, app, "HyprLink", "Запрос подключения")
+	sendNotify(t, app, "telegram", "marker")
+	msg := p.expect("desktop_notification", 2*time.Second)
+	if msg["app"] != "telegram" {
+		t.Errorf("got %v, want HyprLink's own notification skipped", msg)
+	}
+}
+
+func TestPhoneNotificationShown(t *testing.T) {
+	addr := startBus(t)
+	daemon := startDaemon(t, addr)
+	s, _ := startServer(t, server.Options{SessionBusAddress: addr}, nil)
+	p, _ := connect(t, s, hello("notifications"))
+
+	msg := map[string]interface{}{
+		"type": "notification", "id": "chat-1", "app": "Signal", "title": "Alice", "content": "hi",
+		"icon":    base64.StdEncoding.EncodeToString(pngData(t, 256, 64)),
+		"actions": []map[string]string{{"id": "reply", "label": "Reply"}},
+	}
+	waitNotifier(t, p, daemon)
+	p.send(msg)
+	call := nextCall(t, daemon)
+	if call.app != "Signal" {
+		t.Errorf("app name %q, want Signal", call.app)
+	}
+	if len(call.actions) != 2 || call.actions[0] != "reply" || call.actions[1] != "Reply" {
+		t.Errorf("actions %v, want reply/Reply", call.actions)
+	}
+	icon, ok := call.hints["image-data"]
+	if !ok {
+		t.Fatal("no image-data hint")
+	}
+	// (iiibiiay): иконка уменьшена до 128 по большей стороне
+	if fields, _ := icon.Value().([]interface{}); len(fields) != 7 || fields[0] != int32(128) || fields[1] != int32(32) {
+		t.Errorf("image-data %v, want a 128x32 image", icon)
+	}
+
+	// Повтор с тем же id обновляет показанное уведомление
+	msg["content"] = "hi again"
+	p.send(msg)
+	if again := nextCall(t, daemon); again.replaces != call.id {
+		t.Errorf("replaces_id %d, want %d", again.replaces, call.id)
+	}
+}
+
+func TestMutedPhoneNotificationSuppressed(t *testing.T) {
+	addr := startBus(t)
+	daemon := startDaemon(t, addr)
+	bundle := &config.ConfigBundle{Settings: config.DefaultSettings()}
+	bundle.Settings.Notifications.Mute = []config.NotificationRule{{App: "spam*"}}
+	s, _ := startServer(t, server.Options{SessionBusAddress: addr}, bundle)
+	p, _ := connect(t, s, hello("notifications"))
+
+	waitNotifier(t, p, daemon)
+	p.send(map[string]interface{}{"type": "notification", "app": "SpamApp", "title": "muted"})
+	p.send(map[string]interface{}{"type": "notification", "app": "Signal", "title": "marker"})
+	if call := nextCall(t, daemon); call.summary != "marker" {
+		t.Errorf("daemon got %q, want the muted notification skipped", call.summary)
+	}
+}
+
+func TestNotificationActionGoesToOrigin(t *testing.T) {
+	addr := startBus(t)
+	daemon := startDaemon(t, addr)
+	_, first, second := twoPhones(t, server.Options{SessionBusAddress: addr}, "notifications")
+
+	msg := map[string]interface{}{
+		"type": "notification", "id": "chat-1", "app": "Signal", "title": "Alice",
+		"actions": []map[string]string{{"id": "reply", "label": "Reply"}},
+	}
+	waitNotifier(t, first, daemon)
+	first.send(msg)
+	call := nextCall(t, daemon)
+
+	// Сервер подписывается на ActionInvoked асинхронно: нажимаем, пока нажатие не дойдёт
+	var action map[string]interface{}
+	for deadline := time.Now().Add(3 * time.Second); action == nil && time.Now().Before(deadline); {
+		if err := daemon.conn.Emit(notificationsPath, "org.freedesktop.Notifications.ActionInvoked", call.id, "reply"); err != nil {
+			t.Fatal(err)
+		}
+		if msg, ok := first.next(100 * time.Millisecond); ok && msg["type"] == "notification_action" {
+			action = msg
+		}
+	}
+	if action["id"] != "chat-1" || action["action"] != "reply" {
+		t.Errorf("got %v, want reply on chat-1", action)
+	}
+	for {
+		msg, ok := second.next(300 * time.Millisecond)
+		if !ok {
+			break
+		}
+		if msg["type"] == "notification_action" {
+			t.Errorf("action on %s's notification reached phone-two: %v", testDevice, msg)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	pinDigits = 4
+	pinTTL    = 60 * time.Second
+
+	// После maxPinAttempts неудач с одного IP сопряжение блокируется,
+	// каждая следующая блокировка вдвое длиннее предыдущей.
+	maxPinAttempts = 5
+	baseLockout    = 30 * time.Second
+	maxLockout     = time.Hour
+	// Счётчики IP, с которого давно не было ошибок, сбрасываются.
+	failureMemory = 15 * time.Minute
+)
+
+// pairingSession — PIN сопряжения для одного IP. Все подключения с этого IP, пока PIN
+// действует, получают тот же PIN; он гаснет после первого успешного ввода или через pinTTL.
+type pairingSession struct {
+	mu      sync.Mutex
+	pin     string
+	expires time.Time
+	used    bool
+}
+
+type pairingGuard struct {
+	failures    int
+	lockouts    int
+	lockedUntil time.Time
+	lastFailure time.Time
+	// inFlight — подключения, которые ждут ввода PIN. Вместе с failures они не могут
+	// превысить maxPinAttempts, иначе параллельные подключения обходили бы лимит.
+	inFlight int
+	session  *pairingSession
+}
+
+func newPairingSession() (*pairingSession, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		return nil, err
+	}
+	return &pairingSession{
+		pin:     fmt.Sprintf("%0*d", pinDigits, n.Int64()),
+		expires: time.Now().Add(pinTTL),
+	}, nil
+}
+
+func (p *pairingSession) valid() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.used && time.Now().Before(p.expires)
+}
+
+// redeem проверяет PIN и в случае успеха гасит сессию.
+func (p *pairingSession) redeem(pin string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.used || time.Now().After(p.expires) || len(pin) != len(p.pin) {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(pin), []byte(p.pin)) != 1 {
+		return false
+	}
+	p.used = true
+	return true
+}
+
+func (s *Server) notifyPairing(p *pairingSession) {
+	msg := fmt.Sprintf("Введите PIN-код на устройстве: %s", p.pin)
+	if s.fingerprint != "" {
+		msg += fmt.Sprintf("\nОтпечаток сертификата: %s", s.fingerprint)
+	}
+	s.run(Command("notify-send", "-a", notifyAppName, "Запрос подключения", msg))
+}
+
+// guard возвращает счётчики IP; давно не ошибавшийся IP без ожидающих подключений
+// начинает с чистого листа. Вызывается под guardsMu.
+func (s *Server) guard(ip string) *pairingGuard {
+	g, ok := s.pairingGuards[ip]
+	if !ok || (g.inFlight == 0 && time.Since(g.lastFailure) > failureMemory && time.Now().After(g.lockedUntil)) {
+		g = &pairingGuard{}
+		s.pairingGuards[ip] = g
+	}
+	return g
+}
+
+// beginPairing резервирует попытку ввода PIN для ip; wait > 0 — сопряжение сейчас запрещено.
+// Успешный вызов завершается finishPairing или abortPairing.
+func (s *Server) beginPairing(ip string) (session *pairingSession, wait time.Duration, err error) {
+	s.guardsMu.Lock()
+	g := s.guard(ip)
+	if locked := time.Until(g.lockedUntil); locked > 0 {
+		s.guardsMu.Unlock()
+		return nil, locked.Round(time.Second), nil
+	}
+	if g.failures+g.inFlight >= maxPinAttempts {
+		s.guardsMu.Unlock()
+		// Ожидающие подключения закончатся не позже, чем через pinTTL
+		return nil, pinTTL, nil
+	}
+	fresh := false
+	if g.session == nil || !g.session.valid() {
+		if g.session, err = newPairingSession(); err != nil {
+			s.guardsMu.Unlock()
+			return nil, 0, err
+		}
+		fresh = true
+	}
+	g.inFlight++
+	session = g.session
+	s.guardsMu.Unlock()
+
+	if fresh {
+		s.notifyPairing(session)
+	}
+	return session, 0, nil
+}
+
+// abortPairing освобождает попытку, если клиент отключился, не прислав PIN.
+func (s *Server) abortPairing(ip string) {
+	s.guardsMu.Lock()
+	if g, ok := s.pairingGuards[ip]; ok && g.inFlight > 0 {
+		g.inFlight--
+	}
+	s.guardsMu.Unlock()
+}
+
+// finishPairing проверяет PIN зарезервированной попытки. lockout > 0 — IP заблокирован,
+// в том числе если блокировка началась, пока клиент вводил PIN: тогда PIN не проверяется.
+func (s *Server) finishPairing(ip string, session *pairingSession, pin string) (ok bool, lockout time.Duration) {
+	s.guardsMu.Lock()
+	g := s.guard(ip)
+	if g.inFlight > 0 {
+		g.inFlight--
+	}
+	if locked := time.Until(g.lockedUntil); locked > 0 {
+		s.guardsMu.Unlock()
+		return false, locked.Round(time.Second)
+	}
+	if session.redeem(pin) {
+		g.failures, g.lockouts, g.session = 0, 0, nil
+		s.guardsMu.Unlock()
+		return true, 0
+	}
+
+	g.failures++
+	g.lastFailure = time.Now()
+	attempt := g.failures
+	if g.failures >= maxPinAttempts {
+		lockout = baseLockout << g.lockouts
+		if lockout > maxLockout || lockout <= 0 {
+			lockout = maxLockout
+		}
+		g.lockouts++
+		g.failures = 0
+		g.lockedUntil = time.Now().Add(lockout)
+		// После блокировки нужен новый PIN
+		g.session = nil
+	}
+	s.guardsMu.Unlock()
+
+	fmt.Printf("Invalid PIN from %s (attempt %d/%d)\n", ip, attempt, maxPinAttempts)
+	msg := fmt.Sprintf("Неверный PIN с адреса %s (попытка %d из %d)", ip, attempt, maxPinAttempts)
+	if lockout > 0 {
+		fmt.Printf("Pairing from %s locked for %s\n", ip, lockout)
+		msg = fmt.Sprintf("Слишком много неверных PIN с адреса %s. Сопряжение заблокировано на %s", ip, lockout)
+	}
+	s.run(Command("notify-send", "-a", notifyAppName, "-u", "critical", "Ошибка сопряжения", msg))
+	return false, lockout
+}
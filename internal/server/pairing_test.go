@@ -0,0 +1,91 @@
+package server_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/server"
+)
+
+// Параллельные подключения с одного IP не должны получить больше maxPinAttempts попыток
+// и не должны плодить PIN-коды и уведомления.
+func TestPairingParallelAttemptsShareLimit(t *testing.T) {
+	s, runner := startServer(t, server.Options{}, nil)
+
+	const conns = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	prompted := 0
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := dial(t, s)
+			p.send(map[string]interface{}{})
+			resp, ok := p.next(2 * time.Second)
+			if !ok || resp["message"] != "PIN_REQUIRED" {
+				return
+			}
+			mu.Lock()
+			prompted++
+			mu.Unlock()
+			p.send(map[string]interface{}{"pin": "no-such-pin"})
+			p.next(2 * time.Second)
+		}()
+	}
+	wg.Wait()
+
+	if prompted > 5 {
+		t.Errorf("%d connections got to enter a PIN, want at most 5", prompted)
+	}
+	pinNotices := 0
+	for _, c := range runner.Calls() {
+		if strings.Contains(c.String(), "Запрос подключения") {
+			pinNotices++
+		}
+	}
+	if pinNotices != 1 {
+		t.Errorf("%d PIN notifications, want 1", pinNotices)
+	}
+
+	// После исчерпания попыток IP заблокирован
+	p := dial(t, s)
+	p.send(map[string]interface{}{})
+	if resp, _ := p.next(2 * time.Second); resp["message"] != "LOCKED_OUT" {
+		t.Errorf("after failures got %v, want LOCKED_OUT", resp)
+	}
+}
+
+func TestPairingWithPinFromNotification(t *testing.T) {
+	s, runner := startServer(t, server.Options{}, nil)
+
+	p := dial(t, s)
+	p.send(map[string]interface{}{})
+	if resp, _ := p.next(2 * time.Second); resp["message"] != "PIN_REQUIRED" {
+		t.Fatalf("got %v, want PIN_REQUIRED", resp)
+	}
+	notice, ok := runner.WaitFor("Запрос подключения", 2*time.Second)
+	if !ok {
+		t.Fatal("no PIN notification")
+	}
+	_, pin, _ := strings.Cut(notice.String(), "устройстве: ")
+	pin = pin[:4]
+
+	// Второе подключение, пока PIN действует, получает тот же PIN без нового уведомления
+	p2 := dial(t, s)
+	p2.send(map[string]interface{}{})
+	p2.next(2 * time.Second)
+
+	p.send(map[string]interface{}{"pin": pin})
+	resp, _ := p.next(2 * time.Second)
+	if resp["device_id"] == nil || resp["token"] == nil {
+		t.Fatalf("pairing failed: %v", resp)
+	}
+	// Использованный PIN больше не действует
+	p2.send(map[string]interface{}{"pin": pin})
+	if resp, _ := p2.next(2 * time.Second); resp["message"] != "INVALID_PIN" {
+		t.Errorf("reused PIN: got %v, want INVALID_PIN", resp)
+	}
+}
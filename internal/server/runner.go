@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Cmd — внешняя команда. Stdin передаётся процессу напрямую, без shell.
+type Cmd struct {
+	Name  string
+	Args  []string
+	Stdin []byte
+}
+
+func Command(name string, args ...string) Cmd {
+	return Cmd{Name: name, Args: args}
+}
+
+// Shell — команда из конфига пользователя, выполняется через bash.
+func Shell(script string) Cmd {
+	return Command("/bin/bash", "-c", script)
+}
+
+func (c Cmd) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// CommandRunner запускает внешние команды; в тестах его подменяет servertest.RecordingRunner.
+type CommandRunner interface {
+	// Run выполняет команду и возвращает её stdout. Отмена ctx должна убивать процесс.
+	Run(ctx context.Context, cmd Cmd) ([]byte, error)
+	// Stream запускает долгоживущую команду и вызывает onLine для каждой строки stdout.
+	// Возвращается, когда процесс завершился или отменён ctx.
+	Stream(ctx context.Context, cmd Cmd, onLine func(line string)) error
+}
+
+// ExecRunner — CommandRunner на os/exec.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, cmd Cmd) ([]byte, error) {
+	return execCommand(ctx, cmd).Output()
+}
+
+func (ExecRunner) Stream(ctx context.Context, cmd Cmd, onLine func(line string)) error {
+	c := execCommand(ctx, cmd)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		onLine(scanner.Text())
+	}
+	err = c.Wait()
+	if err == nil {
+		err = scanner.Err()
+	}
+	if msg := firstLine(stderr.Bytes()); err != nil && msg != "" {
+		return fmt.Errorf("%v: %s", err, msg)
+	}
+	return err
+}
+
+func execCommand(ctx context.Context, cmd Cmd) *exec.Cmd {
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	if cmd.Stdin != nil {
+		c.Stdin = bytes.NewReader(cmd.Stdin)
+	}
+	// bash -c запускает дочерние процессы; при таймауте убиваем всю группу,
+	// иначе зависший потомок держит stdout и Output не возвращается
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.Cancel = func() error {
+		return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+	}
+	c.WaitDelay = time.Second
+	return c
+}
+
+func (s *Server) run(cmd Cmd) error {
+	_, err := s.runner.Run(s.ctx, cmd)
+	return err
+}
+
+func (s *Server) output(cmd Cmd) ([]byte, error) {
+	return s.runner.Run(s.ctx, cmd)
+}
+
+// runLimited выполняет команду из конфига с таймаутом, занимая один из Options.MaxCommands слотов.
+func (s *Server) runLimited(cmd Cmd, timeout time.Duration) ([]byte, error) {
+	return s.runLimitedCtx(s.ctx, cmd, timeout)
+}
+
+// runLimitedCtx — runLimited с родительским контекстом. Ожидание свободного слота входит в таймаут.
+func (s *Server) runLimitedCtx(parent context.Context, cmd Cmd, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("no free command slot within %s", timeout)
+		}
+		return nil, ctx.Err()
+	}
+
+	out, err := s.runner.Run(ctx, cmd)
+	if err == nil {
+		return out, nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return out, fmt.Errorf("timed out after %s", timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if msg := firstLine(exitErr.Stderr); msg != "" {
+			return out, fmt.Errorf("%v: %s", err, msg)
+		}
+	}
+	return out, err
+}
+
+func firstLine(b []byte) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(string(b)), "\n")
+	return line
+}
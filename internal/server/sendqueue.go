@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+// sendQueue — исходящие сообщения одного клиента. Рассылка только кладёт их в очередь,
+// а пишет в сокет отдельная горутина, поэтому медленный телефон не задерживает остальных.
+type sendQueue struct {
+	conn         net.Conn
+	enc          *json.Encoder
+	size         int
+	writeTimeout time.Duration
+
+	mu     sync.Mutex
+	items  []queued
+	closed bool
+	wake   chan struct{}
+}
+
+type queued struct {
+	// key — сообщения с одинаковым непустым key заменяют друг друга, пока ждут отправки.
+	key string
+	msg interface{}
+	// sent, если задан, вызывается из горутины run после успешной записи msg.
+	sent func()
+}
+
+func newSendQueue(conn net.Conn, size int, writeTimeout time.Duration) *sendQueue {
+	return &sendQueue{
+		conn:         conn,
+		enc:          json.NewEncoder(conn),
+		size:         size,
+		writeTimeout: writeTimeout,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// push ставит сообщение в очередь. Если сообщение с тем же key ещё не отправлено, оно
+// заменяется новым. false — очередь переполнена: клиент не успевает читать.
+func (q *sendQueue) push(key string, msg interface{}) bool {
+	return q.pushThen(key, msg, nil)
+}
+
+// pushThen — push, после которого sent вызывается, когда сообщение записано в сокет.
+// Заменённое сообщение своего sent уже не вызовет.
+func (q *sendQueue) pushThen(key string, msg interface{}, sent func()) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	if key != "" {
+		for i := range q.items {
+			if q.items[i].key == key {
+				q.items[i].msg, q.items[i].sent = msg, sent
+				return true
+			}
+		}
+	}
+	if len(q.items) >= q.size {
+		return false
+	}
+	q.items = append(q.items, queued{key: key, msg: msg, sent: sent})
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// run пишет сообщения до close. Если клиент не принял сообщение за writeTimeout,
+// соединение закрывается, и сессия завершается как при обрыве.
+func (q *sendQueue) run() {
+	for range q.wake {
+		for {
+			// Сообщение остаётся в очереди до отправки, чтобы переполнение видело всё,
+			// что клиент ещё не принял
+			q.mu.Lock()
+			if q.closed || len(q.items) == 0 {
+				q.mu.Unlock()
+				break
+			}
+			msg, sent := q.items[0].msg, q.items[0].sent
+			q.items[0].key = ""
+			q.mu.Unlock()
+
+			q.conn.SetWriteDeadline(time.Now().Add(q.writeTimeout))
+			if err := q.enc.Encode(msg); err != nil {
+				q.conn.Close()
+				q.close()
+				return
+			}
+
+			q.mu.Lock()
+			if len(q.items) > 0 {
+				q.items = q.items[1:]
+			}
+			q.mu.Unlock()
+			if sent != nil {
+				sent()
+			}
+		}
+	}
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.items = nil
+		close(q.wake)
+	}
+}
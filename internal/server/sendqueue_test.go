@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/Monekx/hyprlink/internal/server/servertest"
+)
+
+func TestSendQueueMergesKeyedMessages(t *testing.T) {
+	release, done := make(chan struct{}), make(chan struct{}, 1)
+	var lines []string
+	for i := 1; i <= 50; i++ {
+		lines = append(lines, strconv.Itoa(i))
+	}
+	runner := &servertest.RecordingRunner{StreamHandler: gatedStreams(release, done, lines...)}
+	s, _ := startServer(t, server.Options{Runner: runner}, streamBundle("cpu"))
+	conn := dialSilent(t, s, hello("streams"))
+
+	clogClient(s)
+	close(release)
+	<-done
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	dec := json.NewDecoder(conn)
+	var values []float64
+	for {
+		var msg map[string]interface{}
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatalf("after %v: %v", values, err)
+		}
+		if msg["type"] == "update" && msg["id"] == "cpu" {
+			values = append(values, msg["value"].(float64))
+			if msg["value"] == float64(50) {
+				break
+			}
+		}
+	}
+	if len(values) != 1 {
+		t.Errorf("client got cpu values %v, want only the latest", values)
+	}
+}
+
+func TestSendQueueOverflowDisconnects(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	release, done := make(chan struct{}), make(chan struct{}, len(ids))
+	runner := &servertest.RecordingRunner{StreamHandler: gatedStreams(release, done, "1")}
+	s, _ := startServer(t, server.Options{Runner: runner, SendQueueSize: 4}, streamBundle(ids...))
+	conn := dialSilent(t, s, hello("streams"))
+
+	clogClient(s)
+	close(release)
+	for range ids {
+		<-done
+	}
+	// Разные id не сливаются: очередь переполняется, и клиента отключают
+	if !readUntilClosed(conn, 5*time.Second) {
+		t.Fatal("client with a full queue was not disconnected")
+	}
+}
+
+func TestSendQueueWriteTimeoutDisconnects(t *testing.T) {
+	s, _ := startServer(t, server.Options{WriteTimeout: 200 * time.Millisecond}, nil)
+	conn := dialSilent(t, s, nil)
+
+	clogClient(s)
+	time.Sleep(300 * time.Millisecond)
+	if !readUntilClosed(conn, 5*time.Second) {
+		t.Fatal("client that stopped reading was not disconnected after the write timeout")
+	}
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/hyprland"
+	"github.com/Monekx/hyprlink/internal/media"
+	"github.com/Monekx/hyprlink/internal/notify"
+	"github.com/godbus/dbus/v5"
+)
+
+// Options настраивает Server. Нулевые значения заменяются значениями по умолчанию.
+type Options struct {
+	// Port — TCP-порт; 0 выбирает свободный порт (см. Server.Addr).
+	Port int
+	// ConfigDir — каталог конфига, по умолчанию ~/.config/hyprlink.
+	ConfigDir string
+	// Devices — хранилище доверенных устройств, по умолчанию trusted_devices.json в ConfigDir.
+	Devices *config.TrustedStore
+	// Certificate включает TLS. RequireTLS отключает клиентов без TLS.
+	Certificate *tls.Certificate
+	RequireTLS  bool
+	// HandshakeTimeout — сколько ждать первый байт и TLS-рукопожатие от нового клиента,
+	// по умолчанию DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+	// Runner запускает внешние команды, по умолчанию ExecRunner.
+	Runner CommandRunner
+	// MaxCommands — сколько source и action может выполняться одновременно, по умолчанию DefaultMaxCommands.
+	MaxCommands int
+	// Hyprland — сокеты Hyprland для source_mode: hyprland и dispatch, по умолчанию из окружения.
+	Hyprland *hyprland.Client
+	// SessionBusAddress — адрес сессионной шины D-Bus для MPRIS и уведомлений, по умолчанию
+	// DBUS_SESSION_BUS_ADDRESS. Монитору уведомлений нужно второе соединение, поэтому передаётся адрес.
+	SessionBusAddress string
+	// SendQueueSize — сколько сообщений может ждать отправки одному клиенту, по умолчанию DefaultSendQueueSize.
+	SendQueueSize int
+	// WriteTimeout — сколько ждать, пока клиент примет одно сообщение; дольше — клиент завис.
+	// По умолчанию DefaultWriteTimeout.
+	WriteTimeout time.Duration
+}
+
+const (
+	DefaultMaxCommands      = 8
+	DefaultHandshakeTimeout = 10 * time.Second
+	DefaultSendQueueSize    = 1024
+	DefaultWriteTimeout     = 10 * time.Second
+)
+
+// Server — TCP-сервер HyprLink. В одном процессе может работать несколько серверов.
+type Server struct {
+	opts        Options
+	runner      CommandRunner
+	hyprland    *hyprland.Client
+	slots       chan struct{}
+	devices     *config.TrustedStore
+	tlsConfig   *tls.Config
+	fingerprint string
+
+	mu      sync.Mutex
+	clients map[net.Conn]*client
+	// sessions — оборвавшиеся сессии, которые ещё можно продолжить, см. session.go.
+	sessions map[string]*suspendedSession
+	// conns — все открытые соединения, включая ещё не авторизованные.
+	conns map[net.Conn]struct{}
+	ln    net.Listener
+
+	configMu sync.RWMutex
+	config   *config.UIConfig
+	actions  map[string]config.Action
+	settings config.Settings
+
+	clipHistory clipboardHistory
+
+	// values — последнее значение каждого модуля с source, см. sources.go.
+	valuesMu sync.Mutex
+	values   map[string]Response
+	// streamed — модули, значения которых приходят на каждое изменение (protocol.CapStreams).
+	streamed map[string]bool
+	// sources — запущенные stream- и poll-модули, hyprModules — модули общего подписчика
+	// на события Hyprland; UpdateConfig перезапускает только изменившиеся.
+	sources      map[string]runningSource
+	hyprModules  []config.Module
+	stopHyprland context.CancelFunc
+
+	pendingMu  sync.Mutex
+	pendingGet map[string]*pendingRequest
+
+	guardsMu      sync.Mutex
+	pairingGuards map[string]*pairingGuard
+
+	busOnce sync.Once
+	bus     *dbus.Conn
+	busErr  error
+
+	mediaMu sync.Mutex
+	media   *media.Watcher
+	art     *media.ArtCache
+
+	// showMu показывает уведомления телефона по одному: обновление должно знать id предыдущего.
+	showMu      sync.Mutex
+	notifyMu    sync.Mutex
+	notifier    *notify.Client
+	notifyShown map[phoneNotification]uint32
+	notifyByID  map[uint32]phoneNotification
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(opts Options) *Server {
+	if opts.ConfigDir == "" {
+		home, _ := os.UserHomeDir()
+		opts.ConfigDir = filepath.Join(home, ".config", "hyprlink")
+	}
+	if opts.HandshakeTimeout <= 0 {
+		opts.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if opts.SendQueueSize <= 0 {
+		opts.SendQueueSize = DefaultSendQueueSize
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultWriteTimeout
+	}
+	s := &Server{
+		opts:          opts,
+		runner:        opts.Runner,
+		hyprland:      opts.Hyprland,
+		devices:       opts.Devices,
+		clients:       make(map[net.Conn]*client),
+		sessions:      make(map[string]*suspendedSession),
+		conns:         make(map[net.Conn]struct{}),
+		config:        &config.UIConfig{},
+		actions:       make(map[string]config.Action),
+		settings:      config.DefaultSettings(),
+		values:        make(map[string]Response),
+		pendingGet:    make(map[string]*pendingRequest),
+		pairingGuards: make(map[string]*pairingGuard),
+		art:           media.NewArtCache(artCacheSize),
+		notifyShown:   make(map[phoneNotification]uint32),
+		notifyByID:    make(map[uint32]phoneNotification),
+		ctx:           context.Background(),
+	}
+	if s.runner == nil {
+		s.runner = ExecRunner{}
+	}
+	if s.hyprland == nil {
+		// Вне сессии Hyprland остаётся nil, модули с source_mode: hyprland получат ошибку
+		s.hyprland, _ = hyprland.FromEnv()
+	}
+	if opts.MaxCommands <= 0 {
+		opts.MaxCommands = DefaultMaxCommands
+	}
+	s.slots = make(chan struct{}, opts.MaxCommands)
+	if s.devices == nil {
+		s.devices = config.NewTrustedStore(filepath.Join(opts.ConfigDir, config.TrustedDevicesFile))
+	}
+	if opts.Certificate != nil {
+		s.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{*opts.Certificate},
+			MinVersion:   tls.VersionTLS12,
+		}
+		s.fingerprint = Fingerprint(opts.Certificate.Certificate[0])
+	}
+	return s
+}
+
+// Start начинает слушать порт и сразу возвращается; работа идёт в фоне до Shutdown или отмены ctx.
+func (s *Server) Start(ctx context.Context) error {
+	lc := net.ListenConfig{KeepAlive: 10 * time.Second}
+	ln, err := lc.Listen(ctx, "tcp", fmt.Sprintf(":%d", s.opts.Port))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.ln = ln
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	s.restartSources()
+	s.goLoop(s.watchClipboard)
+	s.goLoop(s.watchMedia)
+	s.goLoop(s.watchNotifications)
+	s.goLoop(s.watchDesktopNotifications)
+	s.goLoop(s.watchTrustedDevices)
+	s.goLoop(s.acceptLoop)
+
+	// Отмена внешнего контекста останавливает сервер так же, как Shutdown
+	go func() {
+		<-s.ctx.Done()
+		s.closeConnections()
+	}()
+	return nil
+}
+
+// sessionBus подключается к сессионной шине при первом обращении.
+func (s *Server) sessionBus() (*dbus.Conn, error) {
+	s.busOnce.Do(func() {
+		s.bus, s.busErr = s.connectSessionBus()
+		if s.busErr != nil {
+			return
+		}
+		bus := s.bus
+		go func() {
+			<-s.ctx.Done()
+			bus.Close()
+		}()
+	})
+	return s.bus, s.busErr
+}
+
+// connectSessionBus открывает новое соединение с сессионной шиной; закрывает его вызывающий.
+func (s *Server) connectSessionBus() (*dbus.Conn, error) {
+	if s.opts.SessionBusAddress == "" {
+		return dbus.ConnectSessionBus()
+	}
+	return dbus.Connect(s.opts.SessionBusAddress)
+}
+
+// Shutdown закрывает слушатель и все подключения и ждёт завершения фоновых горутин.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	s.closeConnections()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Addr возвращает адрес слушателя после Start.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Fingerprint — отпечаток TLS-сертификата сервера или пустая строка без TLS.
+func (s *Server) Fingerprint() string {
+	return s.fingerprint
+}
+
+func (s *Server) UpdateConfig(bundle *config.ConfigBundle) {
+	s.configMu.Lock()
+	s.config = &bundle.UI
+	s.actions = bundle.Actions
+	s.settings = bundle.Settings
+	s.configMu.Unlock()
+	s.restartSources()
+}
+
+func (s *Server) currentConfig() (*config.UIConfig, map[string]config.Action) {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.config, s.actions
+}
+
+func (s *Server) currentSettings() config.Settings {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.settings
+}
+
+func (s *Server) acceptLoop() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || s.ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		s.mu.Lock()
+		if s.ctx.Err() != nil {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.goLoop(func() {
+			defer s.forget(conn)
+			s.acceptConn(conn)
+		})
+	}
+}
+
+func (s *Server) forget(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *Server) closeConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	for conn := range s.clients {
+		conn.Close()
+		delete(s.clients, conn)
+	}
+}
+
+// goLoop запускает горутину, которую Shutdown дождётся.
+func (s *Server) goLoop(fn func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
+}
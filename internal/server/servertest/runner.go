@@ -0,0 +1,99 @@
+// Package servertest содержит помощники для тестов пакета server.
+package servertest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/server"
+)
+
+// RecordingRunner — server.CommandRunner, который ничего не запускает, а запоминает команды.
+type RecordingRunner struct {
+	// Handler, если задан, отвечает на команды; ctx отменяется по таймауту команды, как для
+	// живого процесса. Без него возвращается пустой вывод без ошибки.
+	Handler func(ctx context.Context, cmd server.Cmd) ([]byte, error)
+	// StreamHandler, если задан, выдаёт строки для Stream и решает, когда поток закончится.
+	// Без него Stream ничего не выводит и ждёт отмены контекста, как живой процесс.
+	StreamHandler func(ctx context.Context, cmd server.Cmd, onLine func(string)) error
+
+	mu      sync.Mutex
+	calls   []server.Cmd
+	changed chan struct{}
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, cmd server.Cmd) ([]byte, error) {
+	r.record(cmd)
+	r.mu.Lock()
+	handler := r.Handler
+	r.mu.Unlock()
+
+	if handler != nil {
+		return handler(ctx, cmd)
+	}
+	return nil, nil
+}
+
+func (r *RecordingRunner) Stream(ctx context.Context, cmd server.Cmd, onLine func(string)) error {
+	r.record(cmd)
+	r.mu.Lock()
+	handler := r.StreamHandler
+	r.mu.Unlock()
+
+	if handler != nil {
+		return handler(ctx, cmd, onLine)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (r *RecordingRunner) record(cmd server.Cmd) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, cmd)
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+}
+
+// Calls возвращает копию всех записанных команд.
+func (r *RecordingRunner) Calls() []server.Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]server.Cmd(nil), r.calls...)
+}
+
+func (r *RecordingRunner) Reset() {
+	r.mu.Lock()
+	r.calls = nil
+	r.mu.Unlock()
+}
+
+// WaitFor ждёт команду, строка которой (Cmd.String) содержит substr.
+// Действия сервер выполняет в отдельных горутинах, поэтому проверять Calls сразу нельзя.
+func (r *RecordingRunner) WaitFor(substr string, timeout time.Duration) (server.Cmd, bool) {
+	deadline := time.After(timeout)
+	for {
+		r.mu.Lock()
+		for _, c := range r.calls {
+			if strings.Contains(c.String(), substr) {
+				r.mu.Unlock()
+				return c, true
+			}
+		}
+		if r.changed == nil {
+			r.changed = make(chan struct{})
+		}
+		changed := r.changed
+		r.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return server.Cmd{}, false
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/protocol"
+)
+
+const pingKey = "ping"
+
+// suspendedSession — состояние оборвавшейся сессии, которое телефон может забрать,
+// переподключившись с тем же session в течение connection.resume.
+type suspendedSession struct {
+	deviceID   string
+	configHash string
+	artSize    int
+	sentArt    map[string]bool
+	expires    time.Time
+}
+
+func newSessionID() string {
+	return config.GenerateToken()[:16]
+}
+
+// suspendSession запоминает сессию клиента после обрыва. Вызывается под s.mu.
+func (s *Server) suspendSession(c *client) {
+	now := time.Now()
+	for id, old := range s.sessions {
+		if now.After(old.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	window := s.currentSettings().Connection.Resume.Or(config.DefaultResumeWindow)
+	s.sessions[c.session] = &suspendedSession{
+		deviceID:   c.deviceID,
+		configHash: c.configHash,
+		artSize:    c.artSize,
+		sentArt:    c.sentArt,
+		expires:    now.Add(window),
+	}
+}
+
+// resumeSession переносит в c сессию id устройства c.deviceID, если она ещё не истекла,
+// и закрывает полуоткрытое соединение этой сессии. Вызывается под s.mu.
+func (s *Server) resumeSession(c *client, id string) (configHash string, ok bool) {
+	if id == "" {
+		return "", false
+	}
+	for conn, old := range s.clients {
+		if old.session == id && old.deviceID == c.deviceID {
+			old.replaced = true
+			delete(s.clients, conn)
+			conn.Close()
+			c.session, c.configHash = id, old.configHash
+			c.artSize, c.sentArt = old.artSize, old.sentArt
+			return old.configHash, true
+		}
+	}
+	suspended, found := s.sessions[id]
+	if !found {
+		return "", false
+	}
+	delete(s.sessions, id)
+	if suspended.deviceID != c.deviceID || time.Now().After(suspended.expires) {
+		return "", false
+	}
+	c.session, c.configHash = id, suspended.configHash
+	c.artSize, c.sentArt = suspended.artSize, suspended.sentArt
+	return suspended.configHash, true
+}
+
+// pinged — получает ли клиент ping. Только таким клиентам ставится read deadline:
+// клиент без ping мог бы честно молчать дольше connection.timeout.
+func (c *client) pinged() bool {
+	return protocol.Supports(c.version, c.caps, protocol.TypePing)
+}
+
+// heartbeat пингует телефон каждые connection.heartbeat, пока не закрыт done. Телефон
+// отвечает pong; любое входящее сообщение продлевает read deadline в handleSession.
+func (s *Server) heartbeat(c *client, done <-chan struct{}) {
+	ticker := time.NewTicker(s.currentSettings().Connection.Heartbeat.Or(config.DefaultHeartbeat))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			c.send(pingKey, Response{Type: protocol.TypePing, Value: float64(time.Now().UnixMilli())})
+		}
+	}
+}
+
+// handlePing отвечает pong с тем же value, чтобы телефон мог измерить задержку.
+func (s *Server) handlePing(c *client, data map[string]interface{}) {
+	value, _ := data["value"].(float64)
+	c.send("", Response{Type: protocol.TypePong, Value: value})
+}
+
+// readDeadline — до какого момента ждать следующего сообщения от телефона.
+func (s *Server) readDeadline() time.Time {
+	return time.Now().Add(s.currentSettings().Connection.Timeout.Or(config.DefaultClientTimeout))
+}
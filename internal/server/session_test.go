@@ -0,0 +1,157 @@
+package server_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/Monekx/hyprlink/internal/server/servertest"
+)
+
+// shortConnection — конфиг с короткими connection.*, чтобы heartbeat и таймауты
+// срабатывали за доли секунды.
+func shortConnection(heartbeat, timeout, resume time.Duration) *config.ConfigBundle {
+	settings := config.DefaultSettings()
+	settings.Connection = config.ConnectionConfig{
+		Heartbeat: config.Duration(heartbeat),
+		Timeout:   config.Duration(timeout),
+		Resume:    config.Duration(resume),
+	}
+	return &config.ConfigBundle{Settings: settings, UI: config.UIConfig{Hash: "v1"}}
+}
+
+func TestLegacyClientIsNotTimedOut(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, shortConnection(50*time.Millisecond, 100*time.Millisecond, time.Second))
+	// Без hello — клиент версии 0: ping он не получает, значит и отвечать ему не на что
+	p, _ := connect(t, s, nil)
+	if p.closed(400 * time.Millisecond) {
+		t.Fatal("silent legacy client was disconnected by the read timeout")
+	}
+}
+
+func TestHeartbeatPingsClient(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, shortConnection(50*time.Millisecond, time.Second, time.Second))
+	p, _ := connect(t, s, hello())
+	ping := p.expect("ping", time.Second)
+	if ping["value"] == nil {
+		t.Errorf("ping without a timestamp: %v", ping)
+	}
+}
+
+func TestReadTimeoutClosesSilentClient(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, shortConnection(50*time.Millisecond, 150*time.Millisecond, time.Second))
+	p, _ := connect(t, s, hello())
+	// Телефон получает ping, но не отвечает
+	if !p.closed(time.Second) {
+		t.Fatal("client that stopped answering pings was not disconnected")
+	}
+}
+
+func TestPongKeepsClientConnected(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, shortConnection(50*time.Millisecond, 150*time.Millisecond, time.Second))
+	p, _ := connect(t, s, hello())
+	for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); {
+		ping := p.expect("ping", time.Second)
+		p.send(map[string]interface{}{"type": "pong", "value": ping["value"]})
+	}
+}
+
+// reconnect закрывает соединение p и подключается заново с сессией session.
+func reconnect(t *testing.T, s *server.Server, p *phone, session interface{}) map[string]interface{} {
+	t.Helper()
+	p.conn.Close()
+	_, resp := connect(t, s, resumeHello(session))
+	return resp
+}
+
+func TestResumeInsideWindow(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, shortConnection(time.Second, 3*time.Second, time.Second))
+	p, first := connect(t, s, hello())
+	if first["session"] == nil || first["status"] != "update" {
+		t.Fatalf("first connection got %v, want a session and the config", first)
+	}
+
+	resp := reconnect(t, s, p, first["session"])
+	if resp["resumed"] != true || resp["session"] != first["session"] {
+		t.Errorf("reconnect got %v, want session %v resumed", resp, first["session"])
+	}
+	// Конфиг телефон уже получил, повторять его не нужно даже без hash
+	if resp["status"] != "ok" || resp["config"] != nil {
+		t.Errorf("resumed session got status %v with config %v, want ok without config", resp["status"], resp["config"] != nil)
+	}
+}
+
+func TestResumeOutsideWindow(t *testing.T) {
+	s, _ := startServer(t, server.Options{}, shortConnection(time.Second, 3*time.Second, 100*time.Millisecond))
+	p, first := connect(t, s, hello())
+
+	p.conn.Close()
+	time.Sleep(300 * time.Millisecond)
+	_, resp := connect(t, s, resumeHello(first["session"]))
+	if resp["resumed"] == true || resp["session"] == first["session"] {
+		t.Errorf("expired session was resumed: %v", resp)
+	}
+	if resp["status"] != "update" {
+		t.Errorf("new session got status %v, want update with the config", resp["status"])
+	}
+}
+
+func TestResumeResendsLayoutThatWasNotWritten(t *testing.T) {
+	release, done := make(chan struct{}), make(chan struct{}, 1)
+	runner := &servertest.RecordingRunner{StreamHandler: gatedStreams(release, done, strings.Repeat("x", 32<<20))}
+	bundle := streamBundle("big")
+	bundle.Settings.Connection.Resume = config.Duration(time.Minute)
+	bundle.UI.Hash = "v1"
+	s, _ := startServer(t, server.Options{Runner: runner, WriteTimeout: 100 * time.Millisecond}, bundle)
+	conn := dialSilent(t, s, hello("streams"))
+	var first map[string]interface{}
+	if err := json.NewDecoder(conn).Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	// Значение не помещается в буферы сокета, а телефон больше не читает: раскладка v2
+	// встаёт в очередь за ним и так и не записывается
+	close(release)
+	<-done
+	next := streamBundle()
+	next.Settings.Connection.Resume = config.Duration(time.Minute)
+	next.UI.Hash = "v2"
+	s.UpdateConfig(next)
+	s.BroadcastUpdate(&next.UI)
+	// Обычно к переподключению сработает таймаут записи; если нет, сессию заберёт
+	// новое соединение. В обоих случаях у неё остаётся хэш v1
+	time.Sleep(300 * time.Millisecond)
+
+	_, resp := connect(t, s, resumeHello(first["session"]))
+	if resp["resumed"] != true {
+		t.Fatalf("reconnect got %v, want the session resumed", resp)
+	}
+	if resp["status"] != "update" {
+		t.Errorf("resumed session got status %v, want update: the phone never received layout v2", resp["status"])
+	}
+}
+
+func TestRevokeDisconnectsWithoutSession(t *testing.T) {
+	devices := trustedDevices(t, testDevice)
+	s, _ := startServer(t, server.Options{Devices: devices}, nil)
+	p, first := connect(t, s, hello())
+
+	if err := devices.Revoke(testDevice); err != nil {
+		t.Fatal(err)
+	}
+	if !p.closed(2 * time.Second) {
+		t.Fatal("revoked device stayed connected")
+	}
+	// Даже после повторного сопряжения старая сессия не продолжается
+	time.Sleep(100 * time.Millisecond)
+	if err := devices.Add(testDevice, "Test Phone", testToken, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	_, resp := connect(t, s, resumeHello(first["session"]))
+	if resp["resumed"] == true {
+		t.Errorf("session of a revoked device was resumed: %v", resp)
+	}
+}
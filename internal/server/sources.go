@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/hyprland"
+	"github.com/Monekx/hyprlink/protocol"
+)
+
+const (
+	streamMinBackoff = time.Second
+	streamMaxBackoff = time.Minute
+	// Процесс, проработавший дольше streamStableAfter, считается здоровым, и задержка сбрасывается.
+	streamStableAfter = 30 * time.Second
+)
+
+// runningSource — запущенный опрос или поток одного модуля.
+type runningSource struct {
+	mod  config.Module
+	stop context.CancelFunc
+}
+
+// sameSource — можно ли оставить работать источник, запущенный для a, когда модуль стал b.
+func sameSource(a, b config.Module) bool {
+	return a.Source == b.Source && a.SourceMode == b.SourceMode &&
+		a.Interval == b.Interval && a.Timeout == b.Timeout
+}
+
+// restartSources приводит опрос source к текущему конфигу, по горутине на модуль.
+// Перезапускаются только модули, у которых изменились настройки источника.
+func (s *Server) restartSources() {
+	cfg, _ := s.currentConfig()
+	s.mu.Lock()
+	serverCtx, started := s.ctx, s.cancel != nil
+	s.mu.Unlock()
+	if !started || serverCtx.Err() != nil {
+		// Сервер ещё не запущен или уже остановлен
+		return
+	}
+
+	s.valuesMu.Lock()
+	defer s.valuesMu.Unlock()
+
+	modules := make(map[string]config.Module)
+	if cfg != nil {
+		for _, profile := range cfg.Profiles {
+			collectSources(profile.Modules, modules)
+		}
+	}
+	// Значения удалённых модулей больше не нужны новым клиентам
+	for id := range s.values {
+		if _, ok := modules[id]; !ok {
+			delete(s.values, id)
+		}
+	}
+	for id, run := range s.sources {
+		if mod, ok := modules[id]; !ok || !sameSource(run.mod, mod) {
+			run.stop()
+			delete(s.sources, id)
+		}
+	}
+	if s.sources == nil {
+		s.sources = make(map[string]runningSource)
+	}
+
+	s.streamed = make(map[string]bool)
+	var hyprModules []config.Module
+	for _, mod := range modules {
+		mod := mod
+		if mod.SourceMode == config.SourceHyprland {
+			s.streamed[mod.ID] = true
+			hyprModules = append(hyprModules, mod)
+			continue
+		}
+		if mod.SourceMode == config.SourceStream {
+			s.streamed[mod.ID] = true
+		}
+		if _, running := s.sources[mod.ID]; running {
+			continue
+		}
+		ctx, cancel := context.WithCancel(serverCtx)
+		s.sources[mod.ID] = runningSource{mod: mod, stop: cancel}
+		if mod.SourceMode == config.SourceStream {
+			s.goLoop(func() { s.streamSource(ctx, mod) })
+		} else {
+			s.goLoop(func() { s.pollSource(ctx, mod) })
+		}
+	}
+
+	// Одно подключение к .socket2.sock на все модули; переподключаемся, только если набор изменился
+	sort.Slice(hyprModules, func(i, j int) bool { return hyprModules[i].ID < hyprModules[j].ID })
+	if sameHyprModules(s.hyprModules, hyprModules) {
+		return
+	}
+	if s.stopHyprland != nil {
+		s.stopHyprland()
+		s.stopHyprland = nil
+	}
+	s.hyprModules = hyprModules
+	if len(hyprModules) > 0 {
+		ctx, cancel := context.WithCancel(serverCtx)
+		s.stopHyprland = cancel
+		s.goLoop(func() { s.watchHyprland(ctx, hyprModules) })
+	}
+}
+
+// sameHyprModules сравнивает отсортированные по ID наборы hyprland-модулей.
+func sameHyprModules(a, b []config.Module) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || !sameSource(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func collectSources(modules []config.Module, out map[string]config.Module) {
+	for _, mod := range modules {
+		if mod.Source != "" {
+			if _, seen := out[mod.ID]; !seen {
+				out[mod.ID] = mod
+			}
+		}
+		collectSources(mod.Children, out)
+	}
+}
+
+func (s *Server) pollSource(ctx context.Context, mod config.Module) {
+	interval := mod.Interval.Or(config.DefaultInterval)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		out, err := s.runLimitedCtx(ctx, Shell(mod.Source), mod.Timeout.Or(config.DefaultSourceTimeout))
+		switch {
+		case ctx.Err() != nil:
+			// Конфиг сменился или сервер остановлен
+		case err != nil:
+			s.publishValue(Response{Type: protocol.TypeError, ID: mod.ID, Message: err.Error()})
+		default:
+			s.publishValue(parseUpdate(mod.ID, string(out)))
+		}
+		timer.Reset(interval)
+	}
+}
+
+// streamSource держит запущенной потоковую команду модуля и публикует каждую строку её вывода.
+// Слоты MaxCommands и timeout к потокам не применяются.
+func (s *Server) streamSource(ctx context.Context, mod config.Module) {
+	restartWithBackoff(ctx, func() error {
+		return s.runner.Stream(ctx, Shell(mod.Source), func(line string) {
+			if ctx.Err() == nil {
+				s.publishValue(parseUpdate(mod.ID, line))
+			}
+		})
+	}, func(err error, delay time.Duration) {
+		msg := fmt.Sprintf("stream exited, restarting in %s", delay)
+		if err != nil {
+			msg = fmt.Sprintf("stream failed: %v, restarting in %s", err, delay)
+		}
+		fmt.Printf("Source %s: %s\n", mod.ID, msg)
+		s.publishValue(Response{Type: protocol.TypeError, ID: mod.ID, Message: msg})
+	})
+}
+
+// watchHyprland подписывается на события Hyprland и раздаёт данные события модулям,
+// у которых source совпадает с его именем. При обрыве переподключается с той же задержкой, что и stream.
+func (s *Server) watchHyprland(ctx context.Context, modules []config.Module) {
+	if s.hyprland == nil {
+		for _, mod := range modules {
+			s.publishValue(Response{Type: protocol.TypeError, ID: mod.ID, Message: hyprland.ErrNotRunning.Error()})
+		}
+		return
+	}
+
+	byEvent := make(map[string][]string)
+	for _, mod := range modules {
+		byEvent[mod.Source] = append(byEvent[mod.Source], mod.ID)
+	}
+
+	restartWithBackoff(ctx, func() error {
+		// События приходят только при изменении, поэтому начальное значение запрашиваем отдельно
+		for event, ids := range byEvent {
+			if data, ok := s.hyprland.Current(ctx, event); ok {
+				for _, id := range ids {
+					s.publishValue(parseUpdate(id, data))
+				}
+			}
+		}
+		return s.hyprland.Subscribe(ctx, func(ev hyprland.Event) {
+			for _, id := range byEvent[ev.Name] {
+				s.publishValue(parseUpdate(id, ev.Data))
+			}
+		})
+	}, func(err error, delay time.Duration) {
+		msg := fmt.Sprintf("hyprland events: %v, reconnecting in %s", err, delay)
+		fmt.Println(msg)
+		for _, ids := range byEvent {
+			for _, id := range ids {
+				s.publishValue(Response{Type: protocol.TypeError, ID: id, Message: msg})
+			}
+		}
+	})
+}
+
+// restartWithBackoff вызывает run, пока ctx не отменён, и после каждого выхода ждёт
+// задержку от streamMinBackoff до streamMaxBackoff, о которой сообщает onExit.
+func restartWithBackoff(ctx context.Context, run func() error, onExit func(err error, delay time.Duration)) {
+	backoff := streamMinBackoff
+	for {
+		started := time.Now()
+		err := run()
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > streamStableAfter {
+			backoff = streamMinBackoff
+		}
+		onExit(err, backoff)
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		backoff = min(backoff*2, streamMaxBackoff)
+	}
+}
+
+// parseUpdate превращает вывод source в update: числа идут в value, остальное — в content.
+func parseUpdate(id, output string) Response {
+	strVal := strings.TrimSpace(output)
+	if val, err := strconv.ParseFloat(strings.ReplaceAll(strVal, ",", "."), 64); err == nil {
+		return Response{Type: protocol.TypeUpdate, ID: id, Value: val}
+	}
+	return Response{Type: protocol.TypeUpdate, ID: id, Content: strVal}
+}
+
+// publishValue запоминает значение модуля и рассылает его, только если оно изменилось.
+func (s *Server) publishValue(update Response) {
+	s.valuesMu.Lock()
+	prev, ok := s.values[update.ID]
+	changed := !ok || prev.Type != update.Type || prev.Value != update.Value ||
+		prev.Content != update.Content || prev.Message != update.Message
+	if changed {
+		s.values[update.ID] = update
+	}
+	streamed := s.streamed[update.ID]
+	s.valuesMu.Unlock()
+
+	if changed {
+		s.broadcastKeyed(valueKey(update.ID), func(c *client) (Response, bool) {
+			return update, !streamed || c.caps.Has(protocol.CapStreams)
+		})
+	}
+}
+
+// snapshot — текущие значения всех модулей для только что подключившегося клиента.
+// Без streams значения stream- и hyprland-модулей не включаются.
+func (s *Server) snapshot(streams bool) []Response {
+	s.valuesMu.Lock()
+	defer s.valuesMu.Unlock()
+	out := make([]Response, 0, len(s.values))
+	for id, v := range s.values {
+		if streams || !s.streamed[id] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/server"
+	"github.com/Monekx/hyprlink/internal/server/servertest"
+)
+
+func sourcesBundle(stream, title string) *config.ConfigBundle {
+	bundle := &config.ConfigBundle{Settings: config.DefaultSettings()}
+	bundle.UI.Profiles = []config.Tab{{Name: title, Modules: []config.Module{
+		{ID: "cpu", Type: "label", Source: stream, SourceMode: config.SourceStream},
+		{ID: "clock", Type: "label", Source: "poll-clock", Interval: config.Duration(time.Hour)},
+	}}}
+	return bundle
+}
+
+// countCalls — сколько раз запускалась команда, содержащая substr.
+func countCalls(r *servertest.RecordingRunner, substr string) int {
+	n := 0
+	for _, c := range r.Calls() {
+		if strings.Contains(c.String(), substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUpdateConfigRestartsOnlyChangedSources(t *testing.T) {
+	s, runner := startServer(t, server.Options{}, sourcesBundle("stream-cpu", "Desktop"))
+	if _, ok := runner.WaitFor("stream-cpu", 2*time.Second); !ok {
+		t.Fatal("stream source was not started")
+	}
+	if _, ok := runner.WaitFor("poll-clock", 2*time.Second); !ok {
+		t.Fatal("poll source was not started")
+	}
+
+	// Так выглядит UpdateConfig после правки style.css: источники те же
+	s.UpdateConfig(sourcesBundle("stream-cpu", "Desktop"))
+	s.UpdateConfig(sourcesBundle("stream-cpu", "Renamed"))
+	time.Sleep(100 * time.Millisecond)
+	if n := countCalls(runner, "stream-cpu"); n != 1 {
+		t.Errorf("stream started %d times, want 1", n)
+	}
+	if n := countCalls(runner, "poll-clock"); n != 1 {
+		t.Errorf("poll ran %d times, want 1", n)
+	}
+
+	s.UpdateConfig(sourcesBundle("stream-cpu2", "Renamed"))
+	if _, ok := runner.WaitFor("stream-cpu2", 2*time.Second); !ok {
+		t.Fatal("changed stream source was not restarted")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := countCalls(runner, "poll-clock"); n != 1 {
+		t.Errorf("unchanged poll ran %d times after another module changed, want 1", n)
+	}
+}
+
+func TestStreamRestartsAfterExit(t *testing.T) {
+	var starts atomic.Int32
+	runner := &servertest.RecordingRunner{StreamHandler: func(ctx context.Context, cmd server.Cmd, onLine func(string)) error {
+		// Первый запуск печатает значение и завершается, второй работает до отмены
+		if starts.Add(1) == 1 {
+			onLine("1")
+			return nil
+		}
+		onLine("2")
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	s, _ := startServer(t, server.Options{Runner: runner}, streamBundle("cpu"))
+	p, _ := connect(t, s, hello("streams"))
+
+	// Первое значение могло прийти ещё в снимке при подключении, поэтому ждём ошибку и новое значение
+	deadline := time.Now().Add(3 * time.Second)
+	sawExit := false
+	for {
+		msg, ok := p.next(time.Until(deadline))
+		if !ok {
+			t.Fatalf("stream was not restarted (starts: %d, exit reported: %v)", starts.Load(), sawExit)
+		}
+		if msg["id"] != "cpu" {
+			continue
+		}
+		if msg["type"] == "error" {
+			sawExit = strings.Contains(msg["message"].(string), "stream exited, restarting")
+		}
+		if msg["type"] == "update" && msg["value"] == float64(2) {
+			break
+		}
+	}
+	if !sawExit {
+		t.Error("stream exit was not reported to the client")
+	}
+	if n := countCalls(runner, "stream-cpu"); n != 2 {
+		t.Errorf("stream started %d times, want 2", n)
+	}
+}
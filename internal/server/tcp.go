@@ -6,92 +6,87 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/Monekx/hyprlink/internal/config"
+	"github.com/Monekx/hyprlink/internal/hyprland"
+	"github.com/Monekx/hyprlink/protocol"
+	"github.com/fsnotify/fsnotify"
 )
 
-type Request struct {
-	Type     string  `json:"type"`
-	Pin      string  `json:"pin,omitempty"`
-	DeviceID string  `json:"device_id,omitempty"`
-	Token    string  `json:"token,omitempty"`
-	Hash     string  `json:"hash,omitempty"`
-	ID       string  `json:"id,omitempty"`
-	Value    float64 `json:"value,omitempty"`
-	Content  string  `json:"content,omitempty"`
-	Title    string  `json:"title,omitempty"`
-	App      string  `json:"app,omitempty"`
-}
+// Request и Response — сообщения от телефона и к нему; схема описана в пакете protocol.
+type (
+	Request  = protocol.Message
+	Response = protocol.Message
+)
 
-type Response struct {
-	Type     string           `json:"type,omitempty"`
-	Status   string           `json:"status,omitempty"`
-	DeviceID string           `json:"device_id,omitempty"`
-	Token    string           `json:"token,omitempty"`
-	Message  string           `json:"message,omitempty"`
-	Config   *config.UIConfig `json:"config,omitempty"`
-	ID       string           `json:"id,omitempty"`
-	Value    float64          `json:"value,omitempty"`
-	Content  string           `json:"content,omitempty"`
-	App      string           `json:"app,omitempty"`
-	Duration int64            `json:"duration,omitempty"`
+// client — авторизованное подключение телефона.
+type client struct {
+	out      *sendQueue
+	deviceID string
+	session  string
+	// configHash — хэш конфига, который есть у телефона: меняется только после того,
+	// как раскладка записана в сокет, см. configSent.
+	configHash string
+	// replaced — сессию забрало новое соединение того же телефона, сохранять её не нужно.
+	replaced bool
+	// version и caps — версия протокола и возможности из hello, см. пакет protocol.
+	version int
+	caps    protocol.Capabilities
+	// mediaPlayer — плеер, которым управляет это устройство, если оно его выбирало.
+	mediaPlayer string
+	// artSize — размер обложек из media_art_size; 0 — клиент обложки не получает.
+	artSize int
+	// sentArt — обложки, уже отправленные клиенту, по URL.
+	sentArt map[string]bool
 }
 
-var (
-	currentPin string
-	pinMutex   sync.Mutex
-	clients    = make(map[net.Conn]*json.Encoder)
-	mu         sync.Mutex
-
-	configMu       sync.RWMutex
-	currentConfig  *config.UIConfig
-	currentActions map[string]string
+// serverCapabilities — возможности, которые сервер предлагает в ответ на hello.
+var serverCapabilities = protocol.Capabilities{
+	protocol.CapMedia,
+	protocol.CapClipboard,
+	protocol.CapNotifications,
+	protocol.CapStreams,
+}
 
-	getChan = make(chan map[string]interface{}, 10)
-)
+// send ставит сообщение в очередь клиента, если его версия протокола и возможности
+// знают этот тип; остальные сообщения молча пропускаются. false — очередь переполнена.
+func (c *client) send(key string, resp Response) bool {
+	return c.sendThen(key, resp, nil)
+}
 
-func generateAndNotifyPin() string {
-	pinMutex.Lock()
-	defer pinMutex.Unlock()
-	currentPin = fmt.Sprintf("%04d", time.Now().UnixNano()%10000)
-	exec.Command("notify-send", "-a", "HyprLink", "Запрос подключения",
-		fmt.Sprintf("Введите PIN-код на устройстве: %s", currentPin)).Run()
-	return currentPin
+// sendThen — send, после которого sent вызывается из горутины записи, когда сообщение
+// ушло в сокет.
+func (c *client) sendThen(key string, resp Response, sent func()) bool {
+	if !protocol.Supports(c.version, c.caps, resp.Type) {
+		return true
+	}
+	return c.out.pushThen(key, resp, sent)
 }
 
-func UpdateConfig(cfg *config.UIConfig, actions map[string]string) {
-	configMu.Lock()
-	defer configMu.Unlock()
-	currentConfig = cfg
-	currentActions = actions
+// configSent запоминает, что у клиента есть конфиг hash. Хэш меняется только после
+// записи раскладки: если клиент отвалится раньше, продолженная сессия получит её заново.
+func (s *Server) configSent(c *client, hash string) func() {
+	return func() {
+		s.mu.Lock()
+		c.configHash = hash
+		s.mu.Unlock()
+	}
 }
 
-func StartTCPServer(port int, cfg *config.UIConfig, actions map[string]string) {
-	UpdateConfig(cfg, actions)
-	lc := net.ListenConfig{KeepAlive: 10 * time.Second}
-	ln, err := lc.Listen(context.Background(), "tcp", fmt.Sprintf(":%d", port))
+// layoutJSON кодирует раскладку для поля config.
+func layoutJSON(cfg *config.UIConfig) json.RawMessage {
+	data, err := json.Marshal(cfg)
 	if err != nil {
-		return
-	}
-	go startUpdateLoop()
-	go watchClipboard()
-	go watchMediaStatus()
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			continue
-		}
-		go handleSession(conn)
+		fmt.Printf("Error encoding layout: %v\n", err)
+		return nil
 	}
+	return data
 }
 
-func handleSession(conn net.Conn) {
+func (s *Server) handleSession(conn net.Conn) {
 	decoder := json.NewDecoder(conn)
 	var firstReq Request
 	if err := decoder.Decode(&firstReq); err != nil {
@@ -99,76 +94,165 @@ func handleSession(conn net.Conn) {
 		return
 	}
 
-	if firstReq.Type == "get_request" {
-		handleGetRequest(conn, firstReq)
+	if firstReq.Type == protocol.TypeGetRequest {
+		// get_request — команда hyprlink -mode get на этом же компьютере; она не
+		// авторизуется, поэтому по сети список устройств и их данные не отдаются
+		if !isLoopback(conn) {
+			fmt.Printf("Refusing get_request from %s\n", remoteHost(conn))
+			json.NewEncoder(conn).Encode(map[string]string{"error": "get_request is only accepted from localhost"})
+			conn.Close()
+			return
+		}
+		s.handleGetRequest(conn, firstReq)
 		return
 	}
 
 	encoder := json.NewEncoder(conn)
-	home, _ := os.UserHomeDir()
-	trustedPath := filepath.Join(home, ".config", "hyprlink", "trusted_devices.json")
-	os.MkdirAll(filepath.Dir(trustedPath), 0755)
-	trustedDevices, _ := config.LoadTrustedDevices(trustedPath)
+	// Без hello это клиент, написанный до появления версий протокола
+	version, caps := protocol.MinVersion, protocol.LegacyCapabilities
+	if firstReq.Type == protocol.TypeHello {
+		var ok bool
+		caps = protocol.Negotiate(firstReq.Capabilities, serverCapabilities)
+		version, ok = protocol.NegotiateVersion(firstReq.MinVersion, firstReq.Version)
+		if !ok {
+			fmt.Printf("Client %s uses protocol versions %d-%d, supported %d-%d\n",
+				remoteHost(conn), firstReq.MinVersion, firstReq.Version, protocol.MinVersion, protocol.Version)
+			encoder.Encode(Response{
+				Status:  "error",
+				Message: protocol.ErrUnsupportedVersion,
+				Content: fmt.Sprintf("protocol versions %d-%d are not supported, server supports %d-%d",
+					firstReq.MinVersion, firstReq.Version, protocol.MinVersion, protocol.Version),
+				Version:    protocol.Version,
+				MinVersion: protocol.MinVersion,
+			})
+			conn.Close()
+			return
+		}
+	}
+	remoteIP := remoteHost(conn)
 
 	isAuthorized := false
+	deviceID := firstReq.DeviceID
 	var newID, newToken string
-	if firstReq.DeviceID != "" && firstReq.Token != "" {
-		if dev, ok := trustedDevices[firstReq.DeviceID]; ok && dev.Token == firstReq.Token {
-			isAuthorized = true
-		}
+	if firstReq.DeviceID != "" && firstReq.Token != "" && s.devices.Verify(firstReq.DeviceID, firstReq.Token) {
+		isAuthorized = true
+		s.devices.Touch(firstReq.DeviceID, remoteIP)
 	}
 
 	if !isAuthorized {
-		pin := generateAndNotifyPin()
-		encoder.Encode(Response{Status: "unauthorized", Message: "PIN_REQUIRED"})
-		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		session, wait, err := s.beginPairing(remoteIP)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		if wait > 0 {
+			encoder.Encode(Response{Status: "error", Message: protocol.ErrLockedOut, Value: wait.Seconds()})
+			conn.Close()
+			return
+		}
+		encoder.Encode(Response{Status: "unauthorized", Message: protocol.ErrPinRequired})
+		conn.SetReadDeadline(time.Now().Add(pinTTL))
 		var authReq Request
 		if err := decoder.Decode(&authReq); err != nil {
+			s.abortPairing(remoteIP)
 			conn.Close()
 			return
 		}
-		if authReq.Pin == pin && pin != "" {
-			isAuthorized = true
-			newID = "phone-" + config.GenerateToken()[:8]
-			newToken = config.GenerateToken()
-			config.SaveTrustedDevice(trustedPath, config.TrustedDevice{
-				ID: newID, Token: newToken, Name: "Android Device",
-			})
+		ok, lockout := s.finishPairing(remoteIP, session, authReq.Pin)
+		if !ok {
+			if lockout > 0 {
+				encoder.Encode(Response{Status: "error", Message: protocol.ErrLockedOut, Value: lockout.Seconds()})
+			} else {
+				encoder.Encode(Response{Status: "error", Message: protocol.ErrInvalidPin})
+			}
+			conn.Close()
+			return
+		}
+		isAuthorized = true
+		newID = "phone-" + config.GenerateToken()[:8]
+		newToken = config.GenerateToken()
+		deviceID = newID
+		if err := s.devices.Add(newID, "Android Device", newToken, remoteIP); err != nil {
+			fmt.Printf("Error saving trusted device: %v\n", err)
 		}
 	}
 
-	if !isAuthorized {
-		encoder.Encode(Response{Status: "error", Message: "INVALID_PIN"})
-		conn.Close()
-		return
+	c := &client{out: newSendQueue(conn, s.opts.SendQueueSize, s.opts.WriteTimeout), deviceID: deviceID, version: version, caps: caps}
+	if dev, ok := s.devices.Get(deviceID); ok {
+		c.mediaPlayer = dev.MediaPlayer
 	}
+	go c.out.run()
 
-	conn.SetReadDeadline(time.Time{})
-	mu.Lock()
-	clients[conn] = encoder
-	mu.Unlock()
+	// Ответ и текущие значения модулей встают в очередь до регистрации клиента под тем же
+	// мьютексом, что и рассылка, поэтому обновления не обгоняют конфиг
+	s.mu.Lock()
+	cfg, _ := s.currentConfig()
+	resp := Response{Status: "ok", DeviceID: newID, Token: newToken}
+	phoneHash := firstReq.Hash
+	if hash, ok := s.resumeSession(c, firstReq.Session); ok {
+		resp.Resumed = true
+		// Продолженной сессии конфиг не нужен, если он не менялся, даже без hash в запросе
+		if phoneHash == "" {
+			phoneHash = hash
+		}
+	} else {
+		c.session = newSessionID()
+	}
+	resp.Session = c.session
+	if version > 0 {
+		resp.Version, resp.Capabilities = version, caps
+	}
+	if phoneHash != cfg.Hash {
+		resp.Status = "update"
+		resp.Config = layoutJSON(cfg)
+		c.configHash = phoneHash
+		c.out.pushThen("", resp, s.configSent(c, cfg.Hash))
+	} else {
+		c.configHash = cfg.Hash
+		c.out.push("", resp)
+	}
+	for _, update := range s.snapshot(caps.Has(protocol.CapStreams)) {
+		c.send(valueKey(update.ID), update)
+	}
+	if info, ok := s.mediaInfo(c.mediaPlayer); ok {
+		c.send(mediaInfoKey, info)
+	}
+	s.clients[conn] = c
+	s.mu.Unlock()
 
-	go broadcastMediaStatus()
+	done := make(chan struct{})
+	pinged := c.pinged()
+	if pinged {
+		go s.heartbeat(c, done)
+	}
 
 	defer func() {
-		mu.Lock()
-		delete(clients, conn)
-		mu.Unlock()
+		close(done)
+		// Отозванному устройству продолжать нечего, даже если его снова добавят
+		_, trusted := s.devices.Get(c.deviceID)
+		s.mu.Lock()
+		if !c.replaced {
+			delete(s.clients, conn)
+			if trusted {
+				s.suspendSession(c)
+			}
+		}
+		s.mu.Unlock()
+		c.out.close()
 		conn.Close()
 	}()
 
-	configMu.RLock()
-	cfg := currentConfig
-	configMu.RUnlock()
-
-	resp := Response{Status: "ok", DeviceID: newID, Token: newToken}
-	if firstReq.Hash != cfg.Hash {
-		resp.Status = "update"
-		resp.Config = cfg
+	if !pinged {
+		// Старые клиенты не получают ping и могут молчать сколько угодно: их соединение
+		// закрывается только по обрыву, как до появления heartbeat
+		conn.SetReadDeadline(time.Time{})
 	}
-	encoder.Encode(resp)
-
 	for {
+		// Без сообщений дольше connection.timeout (телефон отвечает хотя бы на ping)
+		// соединение считается полуоткрытым и закрывается
+		if pinged {
+			conn.SetReadDeadline(s.readDeadline())
+		}
 		var raw json.RawMessage
 		if err := decoder.Decode(&raw); err != nil {
 			return
@@ -180,206 +264,295 @@ func handleSession(conn net.Conn) {
 		}
 
 		t, _ := data["type"].(string)
-		if t == "sys_info" {
-			fmt.Println("DEBUG: Received sys_info from phone")
-			select {
-			case getChan <- data:
-			default:
-				fmt.Println("DEBUG: getChan is full, skipping")
-			}
+		if t == protocol.TypeSysInfo {
+			s.deliverGetResponse(deviceID, data)
 			continue
 		}
 
-		handleIncomingMap(data)
+		s.handleIncomingMap(c, data)
 	}
 }
 
-func handleIncomingMap(data map[string]interface{}) {
+func (s *Server) handleIncomingMap(c *client, data map[string]interface{}) {
 	t, _ := data["type"].(string)
 	switch t {
-	case "action":
+	case protocol.TypeAction:
 		id, _ := data["id"].(string)
 		val, _ := data["value"].(float64)
-		go handleAction(id, val)
-	case "clipboard":
-		content, _ := data["content"].(string)
-		if clean := strings.TrimSpace(content); clean != "" {
-			go exec.Command("bash", "-c", fmt.Sprintf("echo -n %q | wl-copy", clean)).Run()
+		if isMediaAction(id) {
+			player, _ := data["player"].(string)
+			go s.handleMediaAction(c, id, val, player)
+		} else {
+			go s.handleAction(id, val)
 		}
-	case "notification":
-		app, _ := data["app"].(string)
-		title, _ := data["title"].(string)
-		content, _ := data["content"].(string)
-		go exec.Command("notify-send", "-a", app, title, content).Run()
-	case "ping":
-		// Просто игнорируем пинг
+	case protocol.TypeMediaArtSize:
+		size, _ := data["value"].(float64)
+		s.setArtSize(c, int(size))
+	case protocol.TypeClipboard:
+		go s.handleClipboard(c, data)
+	case protocol.TypeNotification:
+		go s.handleNotification(c, data)
+	case protocol.TypePing:
+		s.handlePing(c, data)
+	case protocol.TypePong:
+		// Ответ на наш ping; соединение уже продлено самим фактом сообщения
 	}
 }
 
-func handleGetRequest(conn net.Conn, req Request) {
-	mu.Lock()
-	var phoneEncoder *json.Encoder
-	for _, enc := range clients {
-		phoneEncoder = enc
-		break
+// pendingRequest — get_request, ожидающий ответа от конкретного устройства.
+type pendingRequest struct {
+	deviceID string
+	reply    chan map[string]interface{}
+}
+
+func (s *Server) handleGetRequest(conn net.Conn, req Request) {
+	defer conn.Close()
+	out := json.NewEncoder(conn)
+
+	s.mu.Lock()
+	var target *client
+	var candidates []string
+	for _, c := range s.clients {
+		if req.DeviceID != "" && c.deviceID != req.DeviceID {
+			continue
+		}
+		if target == nil {
+			target = c
+		}
+		candidates = append(candidates, c.deviceID)
 	}
-	mu.Unlock()
+	s.mu.Unlock()
 
-	if phoneEncoder == nil {
-		json.NewEncoder(conn).Encode(map[string]string{"error": "No devices connected"})
-		conn.Close()
+	switch {
+	case target == nil && req.DeviceID != "":
+		out.Encode(map[string]string{"error": fmt.Sprintf("Device %s is not connected", req.DeviceID)})
+		return
+	case target == nil:
+		out.Encode(map[string]string{"error": "No devices connected"})
+		return
+	case req.DeviceID == "" && len(candidates) > 1:
+		out.Encode(map[string]interface{}{
+			"error":   "Several devices connected, choose one with -device",
+			"devices": s.describeDevices(candidates),
+		})
 		return
 	}
 
-	for len(getChan) > 0 {
-		<-getChan
-	}
+	requestID := config.GenerateToken()[:16]
+	pending := &pendingRequest{deviceID: target.deviceID, reply: make(chan map[string]interface{}, 1)}
+	s.pendingMu.Lock()
+	s.pendingGet[requestID] = pending
+	s.pendingMu.Unlock()
+	defer func() {
+		s.pendingMu.Lock()
+		delete(s.pendingGet, requestID)
+		s.pendingMu.Unlock()
+	}()
 
-	fmt.Println("DEBUG: Sending get_request to phone...")
-	phoneEncoder.Encode(req)
+	req.RequestID = requestID
+	req.DeviceID = ""
+	if !target.send("", req) {
+		out.Encode(map[string]string{"error": "Failed to reach device " + target.deviceID})
+		return
+	}
 
 	select {
-	case stats := <-getChan:
-		fmt.Println("DEBUG: Forwarding stats to CLI")
-		json.NewEncoder(conn).Encode(stats)
+	case stats := <-pending.reply:
+		out.Encode(stats)
 	case <-time.After(7 * time.Second):
-		fmt.Println("DEBUG: Timeout reached")
-		json.NewEncoder(conn).Encode(map[string]string{"error": "Timeout waiting for phone"})
+		out.Encode(map[string]string{"error": "Timeout waiting for phone"})
 	}
-	conn.Close()
 }
 
-func handleAction(actionID string, actionValue float64) {
-	switch actionID {
-	case "media_play":
-		exec.Command("playerctl", "play").Run()
-		broadcastMediaStatus()
-		return
-	case "media_pause":
-		exec.Command("playerctl", "pause").Run()
-		broadcastMediaStatus()
-		return
-	case "media_next":
-		exec.Command("playerctl", "next").Run()
-		broadcastMediaStatus()
-		return
-	case "media_prev":
-		exec.Command("playerctl", "previous").Run()
-		broadcastMediaStatus()
-		return
-	case "media_seek":
-		exec.Command("playerctl", "position", fmt.Sprintf("%f", actionValue)).Run()
-		broadcastMediaStatus()
+// deliverGetResponse отдаёт sys_info тому get_request, чей request_id пришёл в ответе.
+// Ответ без request_id получает единственный ожидающий запрос к устройству.
+func (s *Server) deliverGetResponse(deviceID string, data map[string]interface{}) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	var pending *pendingRequest
+	if id, _ := data["request_id"].(string); id != "" {
+		pending = s.pendingGet[id]
+	} else {
+		for _, p := range s.pendingGet {
+			if p.deviceID != deviceID {
+				continue
+			}
+			if pending != nil {
+				fmt.Printf("Ambiguous sys_info from %s without request_id, dropped\n", deviceID)
+				return
+			}
+			pending = p
+		}
+	}
+	if pending == nil || pending.deviceID != deviceID {
 		return
 	}
-	configMu.RLock()
-	actions := currentActions
-	configMu.RUnlock()
-	if cmdStr, ok := actions[actionID]; ok {
+	select {
+	case pending.reply <- data:
+	default:
+	}
+}
+
+// describeDevices дополняет id устройств их именами из trusted_devices.json.
+func (s *Server) describeDevices(ids []string) []string {
+	devices, _ := s.devices.List()
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if dev, ok := devices[id]; ok && dev.Name != "" {
+			out = append(out, fmt.Sprintf("%s (%s)", id, dev.Name))
+		} else {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func (s *Server) handleAction(actionID string, actionValue float64) {
+	_, actions := s.currentConfig()
+	if action, ok := actions[actionID]; ok {
 		valStr := fmt.Sprintf("%.0f", actionValue)
-		finalCmd := strings.ReplaceAll(cmdStr, "{v}", valStr)
-		exec.Command("/bin/bash", "-c", finalCmd).Run()
+		timeout := action.Timeout.Or(config.DefaultActionTimeout)
+		var err error
+		if action.Dispatch != "" {
+			err = s.dispatch(strings.ReplaceAll(action.Dispatch, "{v}", valStr), timeout)
+		} else {
+			finalCmd := strings.ReplaceAll(action.Command, "{v}", valStr)
+			_, err = s.runLimited(Shell(finalCmd), timeout)
+		}
+		if err != nil {
+			fmt.Printf("Action %s failed: %v\n", actionID, err)
+			s.broadcastUpdate(Response{Type: protocol.TypeError, ID: actionID, Message: err.Error()})
+		}
 	}
 }
 
-func broadcastMediaStatus() {
-	title, _ := exec.Command("playerctl", "metadata", "title").Output()
-	artist, _ := exec.Command("playerctl", "metadata", "artist").Output()
-	status, _ := exec.Command("playerctl", "status").Output()
-	posRaw, _ := exec.Command("playerctl", "position").Output()
-	durRaw, _ := exec.Command("playerctl", "metadata", "mpris:length").Output()
-	t := strings.TrimSpace(string(title))
-	a := strings.TrimSpace(string(artist))
-	s := strings.ToLower(strings.TrimSpace(string(status)))
-	posFloat, _ := strconv.ParseFloat(strings.TrimSpace(string(posRaw)), 64)
-	posMs := int64(posFloat * 1000)
-	durUs, _ := strconv.ParseInt(strings.TrimSpace(string(durRaw)), 10, 64)
-	durMs := durUs / 1000
-	if t == "" {
-		t = "Ничего не воспроизводится"
-		posMs = 0
-		durMs = 0
-	}
-	broadcastUpdate(Response{
-		Type:     "media_info",
-		Content:  t,
-		App:      a,
-		Status:   s,
-		Value:    float64(posMs),
-		Duration: durMs,
+// dispatch отправляет dispatcher прямо в сокет Hyprland, минуя hyprctl и слоты MaxCommands.
+func (s *Server) dispatch(args string, timeout time.Duration) error {
+	if s.hyprland == nil {
+		return hyprland.ErrNotRunning
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+	return s.hyprland.Dispatch(ctx, args)
+}
+
+// Ключи, по которым неотправленные сообщения заменяются более свежими, см. sendQueue.
+const (
+	layoutKey    = "layout"
+	mediaInfoKey = "media_info"
+)
+
+// valueKey — ключ значения модуля: клиенту нужно только последнее.
+func valueKey(id string) string {
+	return "value:" + id
+}
+
+func (s *Server) broadcastUpdate(resp Response) {
+	s.broadcastEach(func(*client) (Response, bool) { return resp, true })
+}
+
+// broadcastEach рассылает каждому клиенту его собственную версию сообщения;
+// клиенты, для которых build вернул false, ничего не получают.
+func (s *Server) broadcastEach(build func(c *client) (Response, bool)) {
+	s.broadcastKeyed("", build)
+}
+
+// broadcastKeyed — broadcastEach, в котором ещё не отправленное сообщение с тем же key
+// заменяется новым. Клиенты с переполненной очередью отключаются.
+func (s *Server) broadcastKeyed(key string, build func(c *client) (Response, bool)) {
+	s.broadcastQueued(func(c *client) bool {
+		resp, ok := build(c)
+		return !ok || c.send(key, resp)
 	})
 }
 
-func broadcastUpdate(resp Response) {
-	mu.Lock()
+// broadcastQueued вызывает push для каждого клиента под s.mu и отключает тех,
+// для кого он вернул false.
+func (s *Server) broadcastQueued(push func(c *client) bool) {
+	s.mu.Lock()
 	var badConns []net.Conn
-	for conn, encoder := range clients {
-		if err := encoder.Encode(resp); err != nil {
+	for conn, c := range s.clients {
+		if !push(c) {
+			fmt.Printf("Device %s is not reading, disconnecting\n", c.deviceID)
 			badConns = append(badConns, conn)
 		}
 	}
 	for _, conn := range badConns {
-		delete(clients, conn)
+		delete(s.clients, conn)
 		conn.Close()
 	}
-	mu.Unlock()
+	s.mu.Unlock()
 }
 
-func startUpdateLoop() {
-	for {
-		configMu.RLock()
-		cfg := currentConfig
-		configMu.RUnlock()
-		if cfg != nil {
-			for _, profile := range cfg.Profiles {
-				scanModules(profile.Modules)
-			}
-		}
-		time.Sleep(1 * time.Second)
-	}
+// BroadcastUpdate рассылает всем клиентам новую раскладку.
+func (s *Server) BroadcastUpdate(cfg *config.UIConfig) {
+	resp := Response{Type: protocol.TypeUpdateLayout, Status: "update", Config: layoutJSON(cfg)}
+	s.broadcastQueued(func(c *client) bool {
+		return c.sendThen(layoutKey, resp, s.configSent(c, cfg.Hash))
+	})
 }
 
-func scanModules(modules []config.Module) {
-	for _, mod := range modules {
-		if mod.Source != "" {
-			out, err := exec.Command("/bin/bash", "-c", mod.Source).Output()
-			if err == nil {
-				strVal := strings.TrimSpace(string(out))
-				if val, err := strconv.ParseFloat(strings.ReplaceAll(strVal, ",", "."), 64); err == nil {
-					broadcastUpdate(Response{Type: "update", ID: mod.ID, Value: val})
-				} else {
-					broadcastUpdate(Response{Type: "update", ID: mod.ID, Content: strVal})
-				}
-			}
-		}
-		if mod.Children != nil {
-			scanModules(mod.Children)
+// DisconnectRevoked закрывает соединения устройств, которых больше нет в списке доверенных.
+func (s *Server) DisconnectRevoked() {
+	devices, err := s.devices.List()
+	if err != nil {
+		fmt.Printf("Error reading trusted devices: %v\n", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, c := range s.clients {
+		if _, ok := devices[c.deviceID]; !ok {
+			fmt.Printf("Device %s revoked, disconnecting\n", c.deviceID)
+			delete(s.clients, conn)
+			conn.Close()
 		}
 	}
 }
 
-func watchClipboard() {
-	var lastClip string
+// watchTrustedDevices следит за файлом устройств, чтобы hyprlink -mode devices revoke срабатывал сразу.
+func (s *Server) watchTrustedDevices() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Printf("Error watching trusted devices: %v\n", err)
+		return
+	}
+	defer watcher.Close()
+	os.MkdirAll(filepath.Dir(s.devices.Path()), 0755)
+	if err := watcher.Add(filepath.Dir(s.devices.Path())); err != nil {
+		fmt.Printf("Error watching trusted devices: %v\n", err)
+		return
+	}
+	name := filepath.Base(s.devices.Path())
 	for {
-		out, err := exec.Command("wl-paste", "--no-newline").Output()
-		if err == nil {
-			curr := strings.TrimSpace(string(out))
-			if curr != lastClip && curr != "" {
-				lastClip = curr
-				broadcastUpdate(Response{Type: "clipboard", Content: curr})
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
 			}
+			if filepath.Base(event.Name) == name {
+				s.DisconnectRevoked()
+			}
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		case <-s.ctx.Done():
+			return
 		}
-		time.Sleep(2 * time.Second)
 	}
 }
 
-func watchMediaStatus() {
-	for {
-		broadcastMediaStatus()
-		time.Sleep(1 * time.Second)
-	}
+func isLoopback(conn net.Conn) bool {
+	ip := net.ParseIP(remoteHost(conn))
+	return ip != nil && ip.IsLoopback()
 }
 
-func BroadcastUpdate(cfg *config.UIConfig) {
-	broadcastUpdate(Response{Type: "update_layout", Status: "update", Config: cfg})
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
 }
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Monekx/hyprlink/protocol"
+)
+
+const (
+	certFile = "server.crt"
+	keyFile  = "server.key"
+
+	// tlsRecordHandshake — первый байт ClientHello, по нему отличаем TLS от старых клиентов с голым JSON.
+	tlsRecordHandshake = 0x16
+)
+
+// LoadOrCreateCertificate загружает самоподписанный сертификат сервера из dir,
+// а при первом запуске генерирует его. Возвращает сертификат и его SHA-256 отпечаток.
+func LoadOrCreateCertificate(dir string) (tls.Certificate, string, error) {
+	certPath := filepath.Join(dir, certFile)
+	keyPath := filepath.Join(dir, keyFile)
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := generateCertificate(certPath, keyPath); err != nil {
+			return tls.Certificate{}, "", err
+		}
+		cert, err = tls.LoadX509KeyPair(certPath, keyPath)
+	}
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	return cert, Fingerprint(cert.Certificate[0]), nil
+}
+
+// Fingerprint форматирует SHA-256 от DER сертификата как AA:BB:CC:...
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// LocalClientTLS — TLS-конфиг для CLI на той же машине: доверяем только сертификату из dir.
+func LocalClientTLS(dir string) (*tls.Config, error) {
+	pemData, err := os.ReadFile(filepath.Join(dir, certFile))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("%s: no certificate found", certFile)
+	}
+	return &tls.Config{RootCAs: pool, ServerName: "localhost", MinVersion: tls.VersionTLS12}, nil
+}
+
+func generateCertificate(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	dnsNames := []string{"localhost"}
+	if hostname != "" {
+		dnsNames = append(dnsNames, hostname)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "HyprLink " + hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// peekedConn отдаёт сначала байты, уже прочитанные при определении протокола.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// acceptConn определяет, пришёл ли клиент с TLS, и передаёт соединение в handleSession.
+func (s *Server) acceptConn(conn net.Conn) {
+	if s.tlsConfig == nil {
+		s.handleSession(conn)
+		return
+	}
+
+	// Дедлайн снимается только после рукопожатия: иначе клиент, приславший 0x16 и
+	// замолчавший, навсегда занял бы горутину
+	br := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(s.opts.HandshakeTimeout))
+	first, err := br.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	pc := &peekedConn{Conn: conn, r: br}
+	if first[0] == tlsRecordHandshake {
+		tc := tls.Server(pc, s.tlsConfig)
+		ctx, cancel := context.WithTimeout(s.ctx, s.opts.HandshakeTimeout)
+		err := tc.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			fmt.Printf("TLS handshake with %s failed: %v\n", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		s.handleSession(tc)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	if s.opts.RequireTLS {
+		fmt.Printf("Refusing plaintext client %s\n", conn.RemoteAddr())
+		json.NewEncoder(conn).Encode(Response{Status: "error", Message: protocol.ErrTLSRequired})
+		conn.Close()
+		return
+	}
+	s.handleSession(pc)
+}
@@ -0,0 +1,83 @@
+package server_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Monekx/hyprlink/internal/server"
+)
+
+func startTLSServer(t *testing.T, opts server.Options) (*server.Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cert, _, err := server.LoadOrCreateCertificate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.ConfigDir, opts.Certificate, opts.RequireTLS = dir, &cert, true
+	s, _ := startServer(t, opts, nil)
+	return s, dir
+}
+
+func TestTLSClientConnects(t *testing.T) {
+	s, dir := startTLSServer(t, server.Options{})
+	cfg, err := server.LocalClientTLS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", s.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hi := hello()
+	hi["device_id"], hi["token"] = testDevice, testToken
+	if err := json.NewEncoder(conn).Encode(hi); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var resp map[string]interface{}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["status"] != "ok" && resp["status"] != "update" {
+		t.Errorf("hello over TLS got %v", resp)
+	}
+}
+
+func TestPlaintextRefusedWhenTLSRequired(t *testing.T) {
+	s, _ := startTLSServer(t, server.Options{})
+	p, resp := connect(t, s, hello())
+	if resp["message"] != "TLS_REQUIRED" {
+		t.Errorf("plaintext hello got %v, want TLS_REQUIRED", resp)
+	}
+	if !p.closed(2 * time.Second) {
+		t.Error("plaintext client was not disconnected")
+	}
+}
+
+func TestStalledHandshakeIsClosed(t *testing.T) {
+	s, _ := startTLSServer(t, server.Options{HandshakeTimeout: 200 * time.Millisecond})
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Первый байт записи TLS handshake, после которого клиент замолкает
+	if _, err := conn.Write([]byte{0x16}); err != nil {
+		t.Fatal(err)
+	}
+	if !readUntilClosed(conn, 3*time.Second) {
+		t.Fatal("server kept a stalled handshake open")
+	}
+}
@@ -0,0 +1,223 @@
+// Package protocol описывает сетевой протокол HyprLink: JSON-сообщения по одному на строку,
+// версии и возможности, о которых клиент и сервер договариваются в hello.
+package protocol
+
+import "encoding/json"
+
+const (
+	// Version — текущая версия протокола.
+	Version = 1
+	// MinVersion — самая старая версия, с которой сервер ещё работает.
+	MinVersion = 0
+)
+
+// NegotiateVersion выбирает старшую версию, общую для сервера и клиента, который знает
+// версии от clientMin до clientMax. false — общих версий нет.
+func NegotiateVersion(clientMin, clientMax int) (int, bool) {
+	version := clientMax
+	if version > Version {
+		version = Version
+	}
+	if version < MinVersion || version < clientMin {
+		return 0, false
+	}
+	return version, true
+}
+
+// Capability — группа сообщений, которую клиент умеет обрабатывать.
+type Capability string
+
+const (
+	// CapMedia — media_info и media_art.
+	CapMedia Capability = "media"
+	// CapClipboard — clipboard в обе стороны, с mime начиная с версии 1.
+	CapClipboard Capability = "clipboard"
+	// CapNotifications — notification_action и desktop_notification.
+	CapNotifications Capability = "notifications"
+	// CapFiles — передача файлов. Зарезервировано, сервер её пока не предлагает.
+	CapFiles Capability = "files"
+	// CapStreams — значения модулей с source_mode stream и hyprland, которые приходят
+	// на каждое изменение, а не раз в interval.
+	CapStreams Capability = "streams"
+)
+
+// LegacyCapabilities — что понимают клиенты без hello.
+var LegacyCapabilities = Capabilities{CapMedia, CapClipboard, CapStreams}
+
+type Capabilities []Capability
+
+func (cs Capabilities) Has(c Capability) bool {
+	for _, have := range cs {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate оставляет возможности клиента, которые есть и у сервера, в порядке сервера.
+// Неизвестные серверу возможности отбрасываются.
+func Negotiate(client, server Capabilities) Capabilities {
+	out := Capabilities{}
+	for _, c := range server {
+		if client.Has(c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Типы сообщений.
+const (
+	// Клиент → сервер
+	TypeHello        = "hello"
+	TypeAction       = "action"
+	TypeMediaArtSize = "media_art_size"
+	TypeNotification = "notification"
+	TypeSysInfo      = "sys_info"
+
+	// Сервер → клиент
+	TypeUpdate              = "update"
+	TypeUpdateLayout        = "update_layout"
+	TypeError               = "error"
+	TypeMediaInfo           = "media_info"
+	TypeMediaArt            = "media_art"
+	TypeNotificationAction  = "notification_action"
+	TypeDesktopNotification = "desktop_notification"
+	TypeGetRequest          = "get_request"
+
+	// В обе стороны
+	TypeClipboard = "clipboard"
+	TypePing      = "ping"
+	TypePong      = "pong"
+)
+
+// id действий, которые сервер выполняет сам через MPRIS, а не по таблице действий конфига.
+const (
+	ActionMediaPlay    = "media_play"
+	ActionMediaPause   = "media_pause"
+	ActionMediaToggle  = "media_toggle"
+	ActionMediaNext    = "media_next"
+	ActionMediaPrev    = "media_prev"
+	ActionMediaSeek    = "media_seek"
+	ActionMediaShuffle = "media_shuffle"
+	ActionMediaLoop    = "media_loop"
+	// ActionMediaSelect только выбирает плеер для устройства.
+	ActionMediaSelect = "media_select"
+)
+
+// MediaActions — встроенные id media_*. Модуль конфига с таким id и своей командой
+// считается ошибкой: его action никогда бы не выполнился.
+var MediaActions = map[string]bool{
+	ActionMediaPlay:    true,
+	ActionMediaPause:   true,
+	ActionMediaToggle:  true,
+	ActionMediaNext:    true,
+	ActionMediaPrev:    true,
+	ActionMediaSeek:    true,
+	ActionMediaShuffle: true,
+	ActionMediaLoop:    true,
+	ActionMediaSelect:  true,
+}
+
+// Значения message в ответах без type.
+const (
+	ErrPinRequired        = "PIN_REQUIRED"
+	ErrInvalidPin         = "INVALID_PIN"
+	ErrLockedOut          = "LOCKED_OUT"
+	ErrUnsupportedVersion = "UNSUPPORTED_VERSION"
+	ErrTLSRequired        = "TLS_REQUIRED"
+)
+
+// serverMessage — с какой версии и с какой возможностью клиент получает тип сообщения.
+type serverMessage struct {
+	since int
+	needs Capability
+}
+
+var serverMessages = map[string]serverMessage{
+	TypeUpdate:              {0, ""},
+	TypeUpdateLayout:        {0, ""},
+	TypeGetRequest:          {0, ""},
+	TypeMediaInfo:           {0, CapMedia},
+	TypeClipboard:           {0, CapClipboard},
+	TypeError:               {1, ""},
+	TypeMediaArt:            {1, CapMedia},
+	TypeNotificationAction:  {1, CapNotifications},
+	TypeDesktopNotification: {1, CapNotifications},
+	TypePing:                {1, ""},
+	TypePong:                {1, ""},
+}
+
+// Supports — понимает ли клиент версии version с возможностями caps сообщение типа
+// msgType. Ответы на подключение (без type) понимают все, неизвестные типы — никто.
+func Supports(version int, caps Capabilities, msgType string) bool {
+	if msgType == "" {
+		return true
+	}
+	m, ok := serverMessages[msgType]
+	if !ok || version < m.since {
+		return false
+	}
+	return m.needs == "" || caps.Has(m.needs)
+}
+
+// Message — общий конверт всех сообщений; каждый тип использует часть полей.
+type Message struct {
+	Type   string `json:"type,omitempty"`
+	Status string `json:"status,omitempty"`
+
+	// Подключение: hello от клиента и ответ сервера. В hello min_version..version —
+	// версии, которые знает клиент, в ответе version — выбранная сервером версия.
+	Version      int          `json:"version,omitempty"`
+	MinVersion   int          `json:"min_version,omitempty"`
+	Capabilities Capabilities `json:"capabilities,omitempty"`
+	DeviceID     string       `json:"device_id,omitempty"`
+	Token        string       `json:"token,omitempty"`
+	Pin          string       `json:"pin,omitempty"`
+	// Hash — хэш конфига, который уже есть у клиента; при совпадении config не присылается.
+	Hash string `json:"hash,omitempty"`
+	// Session выдаётся сервером; клиент передаёт её при переподключении, чтобы продолжить сессию.
+	Session string `json:"session,omitempty"`
+	Resumed bool   `json:"resumed,omitempty"`
+	// Config — раскладка интерфейса в status update и update_layout.
+	Config json.RawMessage `json:"config,omitempty"`
+
+	Message  string  `json:"message,omitempty"`
+	ID       string  `json:"id,omitempty"`
+	Value    float64 `json:"value,omitempty"`
+	Content  string  `json:"content,omitempty"`
+	Title    string  `json:"title,omitempty"`
+	App      string  `json:"app,omitempty"`
+	Duration int64   `json:"duration,omitempty"`
+	// RequestID связывает get_request с ответом sys_info.
+	RequestID string `json:"request_id,omitempty"`
+
+	// Поля media_info сверх старых content/app/status/value/duration
+	Player  string        `json:"player,omitempty"`
+	Players []MediaPlayer `json:"players,omitempty"`
+	Album   string        `json:"album,omitempty"`
+	ArtURL  string        `json:"art_url,omitempty"`
+	Mime    string        `json:"mime,omitempty"`
+	Action  string        `json:"action,omitempty"`
+	Hidden  bool          `json:"hidden,omitempty"`
+	Shuffle bool          `json:"shuffle,omitempty"`
+	Loop    string        `json:"loop,omitempty"`
+	Rate    float64       `json:"rate,omitempty"`
+}
+
+// MediaPlayer — один плеер в media_info.players.
+type MediaPlayer struct {
+	Name     string  `json:"name"`
+	Identity string  `json:"identity,omitempty"`
+	Status   string  `json:"status"`
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	ArtURL   string  `json:"art_url,omitempty"`
+	Position int64   `json:"position"`
+	Duration int64   `json:"duration,omitempty"`
+	Shuffle  bool    `json:"shuffle,omitempty"`
+	Loop     string  `json:"loop,omitempty"`
+	Rate     float64 `json:"rate,omitempty"`
+}
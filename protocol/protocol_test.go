@@ -0,0 +1,27 @@
+package protocol
+
+import "testing"
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		clientMin, clientMax int
+		want                 int
+		ok                   bool
+	}{
+		{0, 0, 0, true},
+		{0, Version, Version, true},
+		// Клиент новее сервера опускается до версии сервера
+		{0, Version + 1, Version, true},
+		{Version, Version + 5, Version, true},
+		// Клиент уже не умеет версию сервера
+		{Version + 1, Version + 2, 0, false},
+		{MinVersion - 2, MinVersion - 1, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := NegotiateVersion(tt.clientMin, tt.clientMax)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NegotiateVersion(%d, %d) = %d, %v, want %d, %v",
+				tt.clientMin, tt.clientMax, got, ok, tt.want, tt.ok)
+		}
+	}
+}